@@ -0,0 +1,752 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	// timezones of the policy windows shouldn't depend on the system's database
+	_ "time/tzdata"
+
+	"github.com/DggHQ/dggarchiver-config/misc"
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi/transport"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// Proxies are the outbound proxies of a platform, selection is either
+// round_robin or sticky.
+type Proxies struct {
+	URLs      []string      `yaml:"urls"`
+	Selection string        `yaml:"selection"`
+	Pool      *network.Pool `yaml:"-"`
+}
+
+// Confirmation is the step a scraped detection has to pass before it's
+// published, mode is polls, recheck or manifest. Without a mode detections
+// are published right away.
+type Confirmation struct {
+	Mode string `yaml:"mode"`
+	// positive polls in a row the polls mode waits for
+	Polls int `yaml:"polls"`
+	// seconds until the recheck, the loop polls again by then
+	Delay int `yaml:"delay"`
+}
+
+// Confirmation modes.
+const (
+	ConfirmPolls    = "polls"
+	ConfirmRecheck  = "recheck"
+	ConfirmManifest = "manifest"
+)
+
+type KickWebsocket struct {
+	Enabled bool   `yaml:"enabled"`
+	AppKey  string `yaml:"app_key"`
+	Cluster string `yaml:"cluster"`
+}
+
+type Kick struct {
+	Enabled        bool
+	Downloader     string        `yaml:"downloader"`
+	Priority       int           `yaml:"restream_priority"`
+	Channel        string        `yaml:"channel"`
+	HealthCheck    string        `yaml:"healthcheck"`
+	ScraperRefresh int           `yaml:"scraper_refresh"`
+	ProxyURL       string        `yaml:"proxy_url"`
+	Proxies        Proxies       `yaml:"proxies"`
+	ClientProfiles []string      `yaml:"client_profiles"`
+	Websocket      KickWebsocket `yaml:"websocket"`
+	Confirmation   Confirmation  `yaml:"confirmation"`
+}
+
+type Rumble struct {
+	Enabled        bool
+	Downloader     string       `yaml:"downloader"`
+	Priority       int          `yaml:"restream_priority"`
+	Channel        string       `yaml:"channel"`
+	HealthCheck    string       `yaml:"healthcheck"`
+	ScraperRefresh int          `yaml:"scraper_refresh"`
+	Proxies        Proxies      `yaml:"proxies"`
+	Confirmation   Confirmation `yaml:"confirmation"`
+}
+
+type WebSub struct {
+	Enabled     bool   `yaml:"enabled"`
+	Hub         string `yaml:"hub"`
+	CallbackURL string `yaml:"callback_url"`
+	Secret      string `yaml:"secret"`
+	Lease       int    `yaml:"lease"`
+	// path the callback handler is registered on, taken from CallbackURL
+	CallbackPath string `yaml:"-"`
+}
+
+// YouTubeService is one of the API clients the YouTube platform rotates
+// through when the quota of the current one is exceeded.
+type YouTubeService struct {
+	Name    string
+	Service *youtube.Service
+}
+
+type YouTube struct {
+	Enabled          bool
+	Downloader       string       `yaml:"downloader"`
+	Priority         int          `yaml:"restream_priority"`
+	Channel          string       `yaml:"channel"`
+	HealthCheck      string       `yaml:"healthcheck"`
+	ScraperRefresh   int          `yaml:"scraper_refresh"`
+	APIRefresh       int          `yaml:"api_refresh"`
+	ArchiveSecondary bool         `yaml:"archive_secondary_streams"`
+	GoogleCred       string       `yaml:"google_credentials"`
+	ExtraGoogleCreds []string     `yaml:"extra_google_credentials"`
+	APIKeys          []string     `yaml:"api_keys"`
+	QuotaBudget      int          `yaml:"quota_budget"`
+	WebSub           WebSub       `yaml:"websub"`
+	Proxies          Proxies      `yaml:"proxies"`
+	Confirmation     Confirmation `yaml:"confirmation"`
+	Services         []YouTubeService
+}
+
+type HTTP struct {
+	Listen string `yaml:"listen"`
+}
+
+// Network configures the outbound clients of every platform.
+type Network struct {
+	Timeout   int     `yaml:"timeout"`
+	Retries   int     `yaml:"retries"`
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+	UserAgent string  `yaml:"user_agent"`
+}
+
+func (n Network) Options() network.Options {
+	return network.Options{
+		Timeout:   time.Duration(n.Timeout) * time.Second,
+		Retries:   n.Retries,
+		RateLimit: n.RateLimit,
+		Burst:     n.Burst,
+		UserAgent: n.UserAgent,
+	}
+}
+
+// PolicyEvent archives every platform between Start and End, RFC3339
+// timestamps.
+type PolicyEvent struct {
+	Name      string    `yaml:"name"`
+	Start     string    `yaml:"start"`
+	End       string    `yaml:"end"`
+	StartTime time.Time `yaml:"-"`
+	EndTime   time.Time `yaml:"-"`
+}
+
+// PolicyWindow is a daily time window a platform is archived in, From and To
+// are HH:MM in Timezone. A window that ends before it starts wraps around
+// midnight.
+type PolicyWindow struct {
+	Platform string   `yaml:"platform"`
+	Days     []string `yaml:"days"`
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	Timezone string   `yaml:"timezone"`
+	// parsed from the fields above, From and To as offsets from midnight
+	Weekdays   []time.Weekday `yaml:"-"`
+	FromOffset time.Duration  `yaml:"-"`
+	ToOffset   time.Duration  `yaml:"-"`
+	Location   *time.Location `yaml:"-"`
+}
+
+// PolicyOverride forces the decision for a platform, or for every platform
+// if it's *, until Until if it's set.
+type PolicyOverride struct {
+	Platform  string    `yaml:"platform"`
+	Action    string    `yaml:"action"`
+	Until     string    `yaml:"until"`
+	Reason    string    `yaml:"reason"`
+	UntilTime time.Time `yaml:"-"`
+}
+
+// Policy decides whether a detected stream gets archived, see State.Decide
+// in util.
+type Policy struct {
+	// minutes a live stream of a higher priority platform keeps suppressing
+	// the others after it was last seen, 0 keeps it suppressing until its
+	// platform reports it ended
+	PriorityFallback int              `yaml:"priority_fallback"`
+	ArchiveAll       []PolicyEvent    `yaml:"archive_all"`
+	Windows          []PolicyWindow   `yaml:"windows"`
+	Overrides        []PolicyOverride `yaml:"overrides"`
+}
+
+// Sessions correlates concurrent streams on different platforms into one
+// broadcast session.
+type Sessions struct {
+	Enabled bool `yaml:"enabled"`
+	// minutes a stream can start after the others of a session ended
+	Window int `yaml:"window"`
+	// share of title words in common that makes streams the same broadcast
+	TitleSimilarity float64 `yaml:"title_similarity"`
+	// most bits the perceptual hashes of their thumbnails can differ by
+	ThumbnailDistance int `yaml:"thumbnail_distance"`
+}
+
+// Restarts links a stream that starts shortly after the previous one of the
+// same channel ended to it, as its next part.
+type Restarts struct {
+	Enabled bool `yaml:"enabled"`
+	// minutes between the end of a stream and the start of its continuation
+	Window          int     `yaml:"window"`
+	TitleSimilarity float64 `yaml:"title_similarity"`
+}
+
+// Manifests resolves the HLS or DASH manifests of streams and adds them to
+// their jobs.
+type Manifests struct {
+	Enabled bool `yaml:"enabled"`
+	// don't publish jobs whose manifest isn't live, they're tried again on
+	// the next poll
+	SkipDead bool `yaml:"skip_dead"`
+}
+
+// DownloaderRule sends the streams it matches to every one of Downloaders,
+// each as its own job. Conditions that aren't set match every stream.
+type DownloaderRule struct {
+	Name     string `yaml:"name"`
+	Platform string `yaml:"platform"`
+	Channel  string `yaml:"channel"`
+	// values the metadata of the job has to have, e.g. dvr: true for
+	// YouTube streams that can be rewound
+	Attributes map[string]string `yaml:"attributes"`
+	// daily time window the stream has to be detected in, the platform of
+	// the window is ignored
+	Window      *PolicyWindow `yaml:"window"`
+	Downloaders []string      `yaml:"downloaders"`
+}
+
+// Downloaders picks the downloaders of a stream, the first rule that matches
+// wins and streams no rule matches go to the downloader of their platform.
+type Downloaders struct {
+	Rules []DownloaderRule `yaml:"rules"`
+}
+
+// Policy override actions.
+const (
+	PolicyArchive = "archive"
+	PolicySkip    = "skip"
+)
+
+type Notifier struct {
+	Verbose bool
+	// identifies the notifier in the headers of published messages,
+	// defaults to the hostname
+	Instance  string `yaml:"instance"`
+	Platforms struct {
+		YouTube YouTube `yaml:"youtube"`
+		Rumble  Rumble  `yaml:"rumble"`
+		Kick    Kick    `yaml:"kick"`
+	}
+	Policy      Policy            `yaml:"policy"`
+	Sessions    Sessions          `yaml:"sessions"`
+	Restarts    Restarts          `yaml:"restarts"`
+	Manifests   Manifests         `yaml:"manifests"`
+	Downloaders Downloaders       `yaml:"downloaders"`
+	HTTP        HTTP              `yaml:"http"`
+	Network     Network           `yaml:"network"`
+	Plugins     misc.PluginConfig `yaml:"plugins"`
+}
+
+type Config struct {
+	Notifier Notifier        `yaml:"notifier"`
+	NATS     misc.NATSConfig `yaml:"nats"`
+}
+
+// read reads the config file without validating it.
+func (cfg *Config) read() error {
+	_ = godotenv.Load()
+
+	configFile := os.Getenv("CONFIG")
+	if configFile == "" {
+		configFile = "config.yaml"
+	}
+	configBytes, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("config load error: %w", err)
+	}
+
+	if err := yaml.Unmarshal(configBytes, &cfg); err != nil {
+		return fmt.Errorf("YAML unmarshalling error: %w", err)
+	}
+	return nil
+}
+
+func (cfg *Config) Load() {
+	log.Debugf("Loading the service configuration")
+	if err := cfg.read(); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	cfg.Notifier.initialize()
+
+	// NATS Host Name or IP
+	if cfg.NATS.Host == "" {
+		log.Fatalf("Please set the nats:host config variable and restart the service")
+	}
+	// NATS Topic Name
+	if cfg.NATS.Topic == "" {
+		log.Fatalf("Please set the nats:topic config variable and restart the service")
+	}
+	cfg.NATS.Load()
+
+	log.Debugf("Config loaded successfully")
+}
+
+// LoadResolver loads the parts of the configuration resolving a YouTube
+// channel needs: the network settings, the YouTube proxies and API
+// credentials. The defaults are used if there's no config file.
+func (cfg *Config) LoadResolver() {
+	if err := cfg.read(); err != nil {
+		log.Debugf("Resolving with the default settings: %s", err)
+	}
+
+	cfg.Notifier.initializeNetwork()
+	platform := &cfg.Notifier.Platforms.YouTube
+	platform.Proxies.initialize("YouTube")
+	if platform.QuotaBudget == 0 {
+		platform.QuotaBudget = 10000
+	}
+	if platform.GoogleCred != "" || len(platform.ExtraGoogleCreds) > 0 || len(platform.APIKeys) > 0 {
+		cfg.Notifier.createGoogleClients()
+	}
+}
+
+func (notifier *Notifier) validatePlatforms() bool {
+	var enabledPlatforms int
+	platformsValue := reflect.ValueOf(notifier.Platforms)
+	platformsFields := reflect.VisibleFields(reflect.TypeOf(notifier.Platforms))
+	for _, field := range platformsFields {
+		if platformsValue.FieldByName(field.Name).FieldByName("Enabled").Bool() {
+			enabledPlatforms++
+		}
+	}
+	return enabledPlatforms > 0
+}
+
+func (notifier *Notifier) validatePriority() error {
+	var platformPriority []int
+	var numOfEnabledPlatforms int
+	platformsValue := reflect.ValueOf(notifier.Platforms)
+	platformsFields := reflect.VisibleFields(reflect.TypeOf(notifier.Platforms))
+	for _, field := range platformsFields {
+		if platformsValue.FieldByName(field.Name).FieldByName("Enabled").Bool() {
+			numOfEnabledPlatforms++
+			if platformsValue.FieldByName(field.Name).FieldByName("Priority").Int() > 0 {
+				platformPriority = append(platformPriority, int(platformsValue.FieldByName(field.Name).FieldByName("Priority").Int()))
+			}
+		}
+	}
+	if misc.SumArray(platformPriority) == 0 {
+		return nil
+	}
+	sort.Ints(platformPriority)
+	if len(platformPriority) != numOfEnabledPlatforms {
+		return errors.New("Please check if the priority has been set for every enabled platform")
+	}
+	for i := 0; i < numOfEnabledPlatforms; i++ {
+		if platformPriority[i] != i+1 {
+			return errors.New("Please check if priority for every enabled platform is a unique number from 1 to <num of enabled platforms>")
+		}
+	}
+	return nil
+}
+
+func (notifier *Notifier) initialize() {
+	if !notifier.validatePlatforms() {
+		log.Fatalf("Please enable at least one platform and restart the service")
+	}
+
+	if err := notifier.validatePriority(); err != nil {
+		log.Fatalf(err.Error())
+	}
+
+	if notifier.Instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = uuid.NewString()
+		}
+		notifier.Instance = hostname
+	}
+	notifier.initializeNetwork()
+	notifier.initializePolicy()
+	notifier.initializeSessions()
+	notifier.initializeRestarts()
+	notifier.initializeDownloaders()
+
+	// YouTube
+	if notifier.Platforms.YouTube.Enabled {
+		hasCredentials := notifier.Platforms.YouTube.GoogleCred != "" || len(notifier.Platforms.YouTube.ExtraGoogleCreds) > 0 || len(notifier.Platforms.YouTube.APIKeys) > 0
+		// the scraper and websub work without the API
+		if notifier.Platforms.YouTube.APIRefresh != 0 && !hasCredentials {
+			log.Fatalf("Please set the notifier:platform:youtube:google_credentials or the notifier:platform:youtube:api_keys config variable and restart the service")
+		}
+		if notifier.Platforms.YouTube.Channel == "" {
+			log.Fatalf("Please set the notifier:platform:youtube:channel config variable and restart the service")
+		}
+		if notifier.Platforms.YouTube.ScraperRefresh == 0 && notifier.Platforms.YouTube.APIRefresh == 0 && !notifier.Platforms.YouTube.WebSub.Enabled {
+			log.Fatalf("Please set the notifier:platform:youtube:scraper_refresh, the notifier:platform:youtube:api_refresh or the notifier:platform:youtube:websub config variable and restart the service")
+		}
+		if notifier.Platforms.YouTube.Downloader == "" {
+			notifier.Platforms.YouTube.Downloader = "yt-dlp"
+		}
+		if notifier.Platforms.YouTube.QuotaBudget == 0 {
+			notifier.Platforms.YouTube.QuotaBudget = 10000
+		}
+		if notifier.Platforms.YouTube.WebSub.Enabled {
+			notifier.initializeWebSub()
+		}
+		notifier.Platforms.YouTube.Proxies.initialize("YouTube")
+		if hasCredentials {
+			notifier.createGoogleClients()
+		}
+		notifier.Platforms.YouTube.Confirmation.initialize("YouTube")
+	}
+
+	// Rumble
+	if notifier.Platforms.Rumble.Enabled {
+		if notifier.Platforms.Rumble.Channel == "" {
+			log.Fatalf("Please set the notifier:platform:rumble:channel config variable and restart the service")
+		}
+		if notifier.Platforms.Rumble.ScraperRefresh == 0 {
+			log.Fatalf("Please set the notifier:platform:rumble:scraper_refresh config variable and restart the service")
+		}
+		if notifier.Platforms.Rumble.Downloader == "" {
+			notifier.Platforms.Rumble.Downloader = "yt-dlp"
+		}
+		notifier.Platforms.Rumble.Proxies.initialize("Rumble")
+		notifier.Platforms.Rumble.Confirmation.initialize("Rumble")
+	}
+
+	// Kick
+	if notifier.Platforms.Kick.Enabled {
+		if notifier.Platforms.Kick.Channel == "" {
+			log.Fatalf("Please set the notifier:platform:kick:channel config variable and restart the service")
+		}
+		if notifier.Platforms.Kick.ScraperRefresh == 0 {
+			log.Fatalf("Please set the notifier:platform:kick:scraper_refresh config variable and restart the service")
+		}
+		if notifier.Platforms.Kick.Downloader == "" {
+			notifier.Platforms.Kick.Downloader = "yt-dlp"
+		}
+		if notifier.Platforms.Kick.ProxyURL != "" {
+			notifier.Platforms.Kick.Proxies.URLs = append([]string{notifier.Platforms.Kick.ProxyURL}, notifier.Platforms.Kick.Proxies.URLs...)
+		}
+		notifier.Platforms.Kick.Proxies.initialize("Kick")
+		if len(notifier.Platforms.Kick.ClientProfiles) == 0 {
+			notifier.Platforms.Kick.ClientProfiles = []string{"chrome_110", "firefox_110", "safari_16_0"}
+		}
+		if notifier.Platforms.Kick.Websocket.AppKey == "" {
+			notifier.Platforms.Kick.Websocket.AppKey = "32cbd69e4b950bf97679"
+		}
+		if notifier.Platforms.Kick.Websocket.Cluster == "" {
+			notifier.Platforms.Kick.Websocket.Cluster = "us2"
+		}
+		notifier.Platforms.Kick.Confirmation.initialize("Kick")
+	}
+
+	notifier.validateProxies()
+
+	// Lua Plugins
+	if notifier.Plugins.Enabled {
+		if notifier.Plugins.PathToPlugin == "" {
+			log.Fatalf("Please set the notifier:plugins:path config variable and restart the service")
+		}
+	}
+}
+
+func (notifier *Notifier) initializeNetwork() {
+	if notifier.Network.Timeout == 0 {
+		notifier.Network.Timeout = 30
+	}
+	// negative values turn retries and rate limiting off
+	if notifier.Network.Retries == 0 {
+		notifier.Network.Retries = 2
+	}
+	if notifier.Network.RateLimit == 0 {
+		notifier.Network.RateLimit = 1
+	}
+	if notifier.Network.Burst == 0 {
+		notifier.Network.Burst = 5
+	}
+	if notifier.Network.UserAgent == "" {
+		notifier.Network.UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36"
+	}
+}
+
+var policyDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// isPlatform reports whether name is one of the platforms, case insensitive.
+func (notifier *Notifier) isPlatform(name string) bool {
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(notifier.Platforms)) {
+		if strings.EqualFold(field.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (notifier *Notifier) initializePolicy() {
+	policy := &notifier.Policy
+	if policy.PriorityFallback < 0 {
+		log.Fatalf("Please set the notifier:policy:priority_fallback config variable to a positive number of minutes or 0 and restart the service")
+	}
+
+	for i := range policy.ArchiveAll {
+		event := &policy.ArchiveAll[i]
+		var err error
+		if event.StartTime, err = time.Parse(time.RFC3339, event.Start); err != nil {
+			log.Fatalf("Unable to parse the start of the %q event in notifier:policy:archive_all: %s", event.Name, err)
+		}
+		if event.EndTime, err = time.Parse(time.RFC3339, event.End); err != nil {
+			log.Fatalf("Unable to parse the end of the %q event in notifier:policy:archive_all: %s", event.Name, err)
+		}
+		if !event.EndTime.After(event.StartTime) {
+			log.Fatalf("The %q event in notifier:policy:archive_all has to end after it starts", event.Name)
+		}
+	}
+
+	for i := range policy.Windows {
+		window := &policy.Windows[i]
+		if !notifier.isPlatform(window.Platform) {
+			log.Fatalf("Unknown platform %q in notifier:policy:windows", window.Platform)
+		}
+		window.initialize(fmt.Sprintf("the %s window in notifier:policy:windows", window.Platform))
+	}
+
+	for i := range policy.Overrides {
+		override := &policy.Overrides[i]
+		if override.Platform != "*" && !notifier.isPlatform(override.Platform) {
+			log.Fatalf("Unknown platform %q in notifier:policy:overrides", override.Platform)
+		}
+		if override.Action != PolicyArchive && override.Action != PolicySkip {
+			log.Fatalf("Unknown action %q in notifier:policy:overrides, use %s or %s", override.Action, PolicyArchive, PolicySkip)
+		}
+		if override.Until != "" {
+			var err error
+			if override.UntilTime, err = time.Parse(time.RFC3339, override.Until); err != nil {
+				log.Fatalf("Unable to parse the until time of the %s override in notifier:policy:overrides: %s", override.Platform, err)
+			}
+		}
+	}
+}
+
+// initialize parses the window, where describes it in errors.
+func (window *PolicyWindow) initialize(where string) {
+	var err error
+	if window.FromOffset, err = parseClock(window.From); err != nil {
+		log.Fatalf("Unable to parse the from time of %s, use HH:MM: %s", where, err)
+	}
+	if window.ToOffset, err = parseClock(window.To); err != nil {
+		log.Fatalf("Unable to parse the to time of %s, use HH:MM: %s", where, err)
+	}
+	for _, day := range window.Days {
+		weekday, ok := policyDays[strings.ToLower(day)]
+		if !ok {
+			log.Fatalf("Unknown day %q in %s, use mon, tue, wed, thu, fri, sat or sun", day, where)
+		}
+		window.Weekdays = append(window.Weekdays, weekday)
+	}
+	if window.Location, err = time.LoadLocation(window.Timezone); err != nil {
+		log.Fatalf("Unable to load the timezone of %s: %s", where, err)
+	}
+}
+
+func (notifier *Notifier) initializeSessions() {
+	if notifier.Sessions.Window == 0 {
+		notifier.Sessions.Window = 30
+	}
+	if notifier.Sessions.TitleSimilarity == 0 {
+		notifier.Sessions.TitleSimilarity = 0.5
+	}
+	if notifier.Sessions.ThumbnailDistance == 0 {
+		notifier.Sessions.ThumbnailDistance = 10
+	}
+}
+
+func (notifier *Notifier) initializeRestarts() {
+	if notifier.Restarts.Window == 0 {
+		notifier.Restarts.Window = 5
+	}
+	if notifier.Restarts.TitleSimilarity == 0 {
+		notifier.Restarts.TitleSimilarity = 0.5
+	}
+}
+
+func (notifier *Notifier) initializeDownloaders() {
+	for i := range notifier.Downloaders.Rules {
+		rule := &notifier.Downloaders.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Platform != "" && !notifier.isPlatform(rule.Platform) {
+			log.Fatalf("Unknown platform %q in the %s of notifier:downloaders:rules", rule.Platform, rule.Name)
+		}
+		if len(rule.Downloaders) == 0 {
+			log.Fatalf("Please set the downloaders of the %s of notifier:downloaders:rules and restart the service", rule.Name)
+		}
+		if rule.Window != nil {
+			rule.Window.initialize(fmt.Sprintf("the window of the %s of notifier:downloaders:rules", rule.Name))
+		}
+	}
+}
+
+func (confirmation *Confirmation) initialize(platform string) {
+	switch confirmation.Mode {
+	case "", ConfirmPolls, ConfirmRecheck, ConfirmManifest:
+	default:
+		log.Fatalf("Unknown mode %q in notifier:platform:%s:confirmation, use %s, %s or %s", confirmation.Mode, strings.ToLower(platform), ConfirmPolls, ConfirmRecheck, ConfirmManifest)
+	}
+	if confirmation.Polls == 0 {
+		confirmation.Polls = 2
+	}
+	if confirmation.Delay == 0 {
+		confirmation.Delay = 10
+	}
+}
+
+func (proxies *Proxies) initialize(platform string) {
+	var err error
+	proxies.Pool, err = network.NewPool(platform, proxies.URLs, proxies.Selection)
+	if err != nil {
+		log.Fatalf("Unable to parse the notifier:platform:%s:proxies config section: %s", strings.ToLower(platform), err)
+	}
+}
+
+// validateProxies makes sure every enabled platform with proxies got a pool,
+// so none of them are silently ignored.
+func (notifier *Notifier) validateProxies() {
+	platformsValue := reflect.ValueOf(notifier.Platforms)
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(notifier.Platforms)) {
+		platform := platformsValue.FieldByName(field.Name)
+		if !platform.FieldByName("Enabled").Bool() {
+			continue
+		}
+		proxies := platform.FieldByName("Proxies").Interface().(Proxies)
+		if len(proxies.URLs) > 0 && proxies.Pool == nil {
+			log.Fatalf("The notifier:platform:%s:proxies config section is set, but its proxy pool wasn't built", strings.ToLower(field.Name))
+		}
+	}
+}
+
+func (notifier *Notifier) initializeWebSub() {
+	websub := &notifier.Platforms.YouTube.WebSub
+	if websub.CallbackURL == "" {
+		log.Fatalf("Please set the notifier:platform:youtube:websub:callback_url config variable and restart the service")
+	}
+	callbackURL, err := url.Parse(websub.CallbackURL)
+	if err != nil {
+		log.Fatalf("Unable to parse the notifier:platform:youtube:websub:callback_url config variable: %s", err)
+	}
+	websub.CallbackPath = callbackURL.Path
+	if websub.CallbackPath == "" {
+		websub.CallbackPath = "/"
+	}
+	if websub.Hub == "" {
+		websub.Hub = "https://pubsubhubbub.appspot.com/subscribe"
+	}
+	// the hub caps leases at 10 days, renewals are scheduled from whatever it grants
+	if websub.Lease == 0 {
+		websub.Lease = 864000
+	}
+	if notifier.HTTP.Listen == "" {
+		notifier.HTTP.Listen = ":8080"
+	}
+}
+
+func (notifier *Notifier) createGoogleClients() {
+	log.Debugf("Creating Google API clients")
+
+	ctx := context.Background()
+
+	// the API clients share the outbound client of the scrapers' settings
+	client := network.NewClient("youtube_api", notifier.Platforms.YouTube.Proxies.Pool, notifier.Network.Options())
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+
+	credentials := notifier.Platforms.YouTube.ExtraGoogleCreds
+	if notifier.Platforms.YouTube.GoogleCred != "" {
+		credentials = append([]string{notifier.Platforms.YouTube.GoogleCred}, credentials...)
+	}
+
+	for _, cred := range credentials {
+		credpath := filepath.Join(".", cred)
+		b, err := os.ReadFile(credpath)
+		if err != nil {
+			log.Fatalf("Unable to read client secret file: %v", err)
+		}
+
+		googleCfg, err := google.JWTConfigFromJSON(b, "https://www.googleapis.com/auth/youtube.readonly")
+		if err != nil {
+			log.Fatalf("Unable to parse client secret file to config: %v", err)
+		}
+		client := googleCfg.Client(ctx)
+
+		service, err := youtube.NewService(ctx, option.WithHTTPClient(client))
+		if err != nil {
+			log.Fatalf("Unable to retrieve YouTube client: %v", err)
+		}
+		notifier.Platforms.YouTube.Services = append(notifier.Platforms.YouTube.Services, YouTubeService{
+			Name:    cred,
+			Service: service,
+		})
+	}
+
+	for _, key := range notifier.Platforms.YouTube.APIKeys {
+		// a custom client takes precedence over the key, so it has to add it itself
+		service, err := youtube.NewService(ctx, option.WithHTTPClient(&http.Client{
+			Timeout:   client.Timeout,
+			Transport: &transport.APIKey{Key: key, Transport: client.Transport},
+		}))
+		if err != nil {
+			log.Fatalf("Unable to retrieve YouTube client: %v", err)
+		}
+		// only the end of the key is used to tell them apart in logs and metrics
+		name := key
+		if len(name) > 4 {
+			name = name[len(name)-4:]
+		}
+		notifier.Platforms.YouTube.Services = append(notifier.Platforms.YouTube.Services, YouTubeService{
+			Name:    fmt.Sprintf("api_key_...%s", name),
+			Service: service,
+		})
+	}
+
+	log.Debugf("Created %d Google API client(s) successfully", len(notifier.Platforms.YouTube.Services))
+}
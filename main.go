@@ -1,12 +1,14 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"reflect"
 	"sync"
 	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
 	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
 	"github.com/DggHQ/dggarchiver-notifier/platforms/kick"
 	"github.com/DggHQ/dggarchiver-notifier/platforms/rumble"
 	"github.com/DggHQ/dggarchiver-notifier/platforms/yt"
@@ -22,6 +24,20 @@ func init() {
 }
 
 func main() {
+	resolve := flag.String("resolve", "", "print the YouTube channel ID of a handle, channel URL or video URL and exit")
+	flag.Parse()
+
+	if *resolve != "" {
+		cfg := config.Config{}
+		cfg.LoadResolver()
+		id, err := yt.ResolveChannelID(&cfg, &util.State{}, *resolve)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		fmt.Println(id)
+		return
+	}
+
 	cfg := config.Config{}
 	cfg.Load()
 
@@ -35,6 +51,10 @@ func main() {
 	}
 	state.Load()
 
+	if cfg.Notifier.Platforms.YouTube.Enabled {
+		yt.ResolveConfigChannel(&cfg, &state)
+	}
+
 	var wg sync.WaitGroup
 	log.Infof("Running the notifier service in continuous mode...")
 
@@ -58,14 +78,20 @@ func main() {
 					log.Infof("Checking YT API every %d minute(s)", cfg.Notifier.Platforms.YouTube.APIRefresh)
 					sleepTime := time.Second * 60 * time.Duration(cfg.Notifier.Platforms.YouTube.APIRefresh)
 					wg.Add(1)
-					yt.StartYTThread("[YT] [API]", yt.LoopAPILivestream, &cfg, &state, sleepTime)
+					util.StartThread("[YT] [API]", "YouTube", yt.LoopAPILivestream, &cfg, &state, sleepTime, nil)
 				}
 
 				if cfg.Notifier.Platforms.YouTube.ScraperRefresh != 0 {
 					log.Infof("Checking YT scraped page every %d minute(s)", cfg.Notifier.Platforms.YouTube.ScraperRefresh)
 					sleepTime := time.Second * 60 * time.Duration(cfg.Notifier.Platforms.YouTube.ScraperRefresh)
 					wg.Add(1)
-					yt.StartYTThread("[YT] [SCRAPER]", yt.LoopScrapedLivestream, &cfg, &state, sleepTime)
+					util.StartThread("[YT] [SCRAPER]", "YouTube", yt.LoopScrapedLivestream, &cfg, &state, sleepTime, nil)
+				}
+
+				if cfg.Notifier.Platforms.YouTube.WebSub.Enabled {
+					log.Infof("Listening for YT WebSub notifications at %s", cfg.Notifier.Platforms.YouTube.WebSub.CallbackURL)
+					wg.Add(1)
+					yt.StartWebSubThread(&cfg, &state)
 				}
 			}
 			time.Sleep(1 * time.Second)
@@ -75,8 +101,9 @@ func main() {
 				if cfg.Notifier.Platforms.Rumble.ScraperRefresh != 0 {
 					log.Infof("Checking Rumble scraped page every %d minute(s)", cfg.Notifier.Platforms.Rumble.ScraperRefresh)
 					sleepTime := time.Second * 60 * time.Duration(cfg.Notifier.Platforms.Rumble.ScraperRefresh)
+					rumble.InitializeRumbleScraper(&cfg)
 					wg.Add(1)
-					rumble.StartRumbleThread("[Rumble] [SCRAPER]", rumble.LoopScrapedLivestream, &cfg, &state, sleepTime)
+					util.StartThread("[Rumble] [SCRAPER]", "Rumble", rumble.LoopScrapedLivestream, &cfg, &state, sleepTime, nil)
 				}
 			}
 			time.Sleep(1 * time.Second)
@@ -88,7 +115,12 @@ func main() {
 					sleepTime := time.Second * 60 * time.Duration(cfg.Notifier.Platforms.Kick.ScraperRefresh)
 					kick.InitializeKickScraper(&cfg)
 					wg.Add(1)
-					kick.StartKickThread("[Kick] [SCRAPER]", kick.LoopScrapedLivestream, &cfg, &state, sleepTime)
+					util.StartThread("[Kick] [SCRAPER]", "Kick", kick.LoopScrapedLivestream, &cfg, &state, sleepTime, kick.Trigger())
+
+					if cfg.Notifier.Platforms.Kick.Websocket.Enabled {
+						log.Infof("Listening for Kick websocket events")
+						kick.StartKickWebsocket(&cfg)
+					}
 				}
 			}
 			time.Sleep(1 * time.Second)
@@ -98,5 +130,9 @@ func main() {
 		}
 	}
 
+	if cfg.Notifier.HTTP.Listen != "" {
+		util.StartHTTPServer(cfg.Notifier.HTTP.Listen)
+	}
+
 	wg.Wait()
 }
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+const (
+	// failures in a row that open a breaker
+	breakerThreshold   = 5
+	breakerCooldown    = 5 * time.Minute
+	breakerMaxCooldown = time.Hour
+)
+
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerOpenError is returned instead of calling an upstream endpoint whose
+// breaker is open.
+type BreakerOpenError struct {
+	Name       string
+	RetryAfter time.Duration
+}
+
+func (err *BreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of %s is open, probing again in %.f seconds", err.Name, err.RetryAfter.Seconds())
+}
+
+func (err *BreakerOpenError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+// Breaker stops calls to an upstream endpoint after it fails too many times
+// in a row. Once the cooldown passes, a single half-open probe is let
+// through: it closes the breaker if it succeeds, otherwise the breaker opens
+// again with a doubled cooldown.
+type Breaker struct {
+	name string
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	cooldown time.Duration
+	openedAt time.Time
+	probing  bool
+}
+
+var (
+	breakers     = make(map[string]*Breaker)
+	breakersLock sync.Mutex
+)
+
+// GetBreaker returns the breaker of the endpoint called name.
+func GetBreaker(name string) *Breaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+
+	breaker, ok := breakers[name]
+	if !ok {
+		breaker = &Breaker{
+			name:     name,
+			state:    BreakerClosed,
+			cooldown: breakerCooldown,
+		}
+		breakers[name] = breaker
+		metrics.Set("breaker_state", name, 0)
+	}
+	return breaker
+}
+
+func (breaker *Breaker) setState(state string) {
+	if breaker.state == state {
+		return
+	}
+	log.Infof("[BREAKER] %s: %s -> %s", breaker.name, breaker.state, state)
+	breaker.state = state
+	metrics.Add("breaker_transitions", fmt.Sprintf("%s %s", breaker.name, state), 1)
+	switch state {
+	case BreakerClosed:
+		metrics.Set("breaker_state", breaker.name, 0)
+	case BreakerHalfOpen:
+		metrics.Set("breaker_state", breaker.name, 1)
+	case BreakerOpen:
+		metrics.Set("breaker_state", breaker.name, 2)
+	}
+}
+
+// IsOpen reports whether calls would be refused right now, without taking
+// the half-open probe.
+func (breaker *Breaker) IsOpen() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case BreakerOpen:
+		return time.Since(breaker.openedAt) < breaker.cooldown
+	case BreakerHalfOpen:
+		return breaker.probing
+	default:
+		return false
+	}
+}
+
+// Allow returns a BreakerOpenError if the endpoint shouldn't be called. Every
+// allowed call has to be followed by Record.
+func (breaker *Breaker) Allow() error {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case BreakerOpen:
+		remaining := breaker.cooldown - time.Since(breaker.openedAt)
+		if remaining > 0 {
+			return &BreakerOpenError{Name: breaker.name, RetryAfter: remaining}
+		}
+		breaker.setState(BreakerHalfOpen)
+		breaker.probing = true
+	case BreakerHalfOpen:
+		if breaker.probing {
+			return &BreakerOpenError{Name: breaker.name, RetryAfter: time.Second}
+		}
+		breaker.probing = true
+	}
+	return nil
+}
+
+// Record records the outcome of an allowed call, only errors that say
+// something about the endpoint's health should be passed.
+func (breaker *Breaker) Record(err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	breaker.probing = false
+	if err == nil {
+		breaker.failures = 0
+		breaker.cooldown = breakerCooldown
+		breaker.setState(BreakerClosed)
+		return
+	}
+
+	breaker.failures++
+	switch {
+	case breaker.state == BreakerHalfOpen:
+		breaker.cooldown *= 2
+		if breaker.cooldown > breakerMaxCooldown {
+			breaker.cooldown = breakerMaxCooldown
+		}
+		breaker.openedAt = time.Now()
+		breaker.setState(BreakerOpen)
+	case breaker.failures >= breakerThreshold:
+		breaker.openedAt = time.Now()
+		breaker.setState(BreakerOpen)
+	}
+}
+
+// Do calls f if the breaker allows it and records its error.
+func (breaker *Breaker) Do(f func() error) error {
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+	err := f()
+	breaker.Record(err)
+	return err
+}
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"golang.org/x/time/rate"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// Options are the settings shared by the outbound clients of every platform.
+type Options struct {
+	Timeout time.Duration
+	// how many times a failed GET is retried
+	Retries int
+	// requests per second allowed to a single host, and the burst on top
+	RateLimit float64
+	Burst     int
+	// set on requests that don't have a User-Agent of their own
+	UserAgent string
+}
+
+var (
+	limiters     = make(map[string]*rate.Limiter)
+	limitersLock sync.Mutex
+)
+
+// Wait blocks until the token bucket of host allows another request. The
+// bucket is shared by every client, so platforms hitting the same host don't
+// add up.
+func Wait(ctx context.Context, host string, options Options) error {
+	if options.RateLimit <= 0 {
+		return nil
+	}
+
+	limitersLock.Lock()
+	limiter, ok := limiters[host]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(options.RateLimit), options.Burst)
+		limiters[host] = limiter
+	}
+	limitersLock.Unlock()
+
+	return limiter.Wait(ctx)
+}
+
+type cachedClient struct {
+	client  *http.Client
+	pool    *Pool
+	options Options
+}
+
+// clients are kept per name so connections get reused across polls
+var (
+	clients     = make(map[string]cachedClient)
+	clientsLock sync.Mutex
+)
+
+// NewClient returns the client called name, creating it the first time. Its
+// requests go through pool, wait for the per-host rate limit and GETs get
+// retried with jittered backoff. Later calls with another pool or other
+// options replace the client, the ones handed out before keep the old
+// settings.
+func NewClient(name string, pool *Pool, options Options) *http.Client {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	if cached, ok := clients[name]; ok {
+		if cached.pool == pool && cached.options == options {
+			return cached.client
+		}
+		log.Warnf("[%s] Client created again with another proxy pool or other options, replacing it", name)
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxIdleConnsPerHost = 10
+	base.ResponseHeaderTimeout = options.Timeout
+	base.TLSHandshakeTimeout = options.Timeout
+
+	// the timeout of a single attempt is up to the transport, the client's
+	// one caps the request with all of its retries
+	retries := options.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	client := &http.Client{
+		Timeout: options.Timeout*time.Duration(retries+1) + retryMaxDelay*time.Duration(retries),
+		Transport: &retryTransport{
+			name:    name,
+			options: options,
+			next:    pool.Transport(base),
+		},
+	}
+	clients[name] = cachedClient{client: client, pool: pool, options: options}
+	return client
+}
+
+type retryTransport struct {
+	name    string
+	options Options
+	next    http.RoundTripper
+}
+
+func isIdempotent(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff doubles the delay with every attempt and adds up to 50% of jitter,
+// unless the server said how long to wait.
+func backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 && time.Duration(seconds)*time.Second <= retryMaxDelay {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" && t.options.UserAgent != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.options.UserAgent)
+	}
+
+	retries := t.options.Retries
+	if !isIdempotent(req) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := Wait(req.Context(), strings.ToLower(req.URL.Hostname()), t.options); err != nil {
+			return nil, err
+		}
+
+		// the request of the caller can't be modified, so retries send a
+		// copy with a fresh body
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt >= retries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := backoff(attempt, resp)
+		if err != nil {
+			log.Debugf("[%s] Request to %s failed, retrying in %.1f seconds: %v", t.name, req.URL.Host, delay.Seconds(), err)
+		} else {
+			log.Debugf("[%s] Got status code %d from %s, retrying in %.1f seconds", t.name, resp.StatusCode, req.URL.Host, delay.Seconds())
+			resp.Body.Close()
+		}
+		metrics.Add("http_retries", t.name, 1)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"expvar"
+	"sync"
+)
+
+// Metrics is a set of metric groups published under name on /debug/vars.
+type Metrics struct {
+	// the groups get created on their first metric, from any goroutine
+	mu   sync.Mutex
+	root *expvar.Map
+}
+
+// NewMetrics publishes a new set of metrics called name.
+func NewMetrics(name string) *Metrics {
+	return &Metrics{root: expvar.NewMap(name)}
+}
+
+func (metrics *Metrics) group(group string) *expvar.Map {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	if m, ok := metrics.root.Get(group).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	metrics.root.Set(group, m)
+	return m
+}
+
+// Set sets the value of the metric name in group.
+func (metrics *Metrics) Set(group string, name string, value int64) {
+	v := new(expvar.Int)
+	v.Set(value)
+	metrics.group(group).Set(name, v)
+}
+
+// Add adds delta to the metric name in group.
+func (metrics *Metrics) Add(group string, name string, delta int64) {
+	metrics.group(group).Add(name, delta)
+}
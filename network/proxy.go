@@ -0,0 +1,203 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+)
+
+// Proxy selection strategies.
+const (
+	SelectionRoundRobin = "round_robin"
+	SelectionSticky     = "sticky"
+)
+
+const (
+	proxyCooldown    = 30 * time.Second
+	proxyMaxCooldown = 30 * time.Minute
+)
+
+var ErrUnsupportedProxy = errors.New("unsupported proxy scheme, use http, https or socks5")
+
+// metrics are served along with the notifier's on /debug/vars
+var metrics = NewMetrics("network")
+
+type Proxy struct {
+	URL      *url.URL
+	failures int
+	badUntil time.Time
+}
+
+// String returns the proxy URL without its password, for logs and metrics.
+func (proxy *Proxy) String() string {
+	return proxy.URL.Redacted()
+}
+
+// Pool hands out the proxies of a platform, skipping the ones that failed
+// recently. A nil pool has no proxies and everything goes out directly.
+type Pool struct {
+	name      string
+	selection string
+
+	mu      sync.Mutex
+	proxies []*Proxy
+	current int
+}
+
+func NewPool(name string, urls []string, selection string) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	if selection == "" {
+		selection = SelectionRoundRobin
+	}
+	if selection != SelectionRoundRobin && selection != SelectionSticky {
+		return nil, fmt.Errorf("unknown proxy selection %q, use %s or %s", selection, SelectionRoundRobin, SelectionSticky)
+	}
+
+	pool := &Pool{
+		name:      name,
+		selection: selection,
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxy, u.Redacted())
+		}
+		proxy := &Proxy{URL: u}
+		pool.proxies = append(pool.proxies, proxy)
+		metrics.Set("proxy_healthy", pool.metricName(proxy), 1)
+	}
+	return pool, nil
+}
+
+func (pool *Pool) metricName(proxy *Proxy) string {
+	return fmt.Sprintf("%s %s", pool.name, proxy)
+}
+
+// Pick returns the proxy to use for the next request, or nil if the pool is
+// empty. Round robin moves on with every request while sticky stays on a
+// proxy until it fails. If every proxy is marked bad, the one that's closest
+// to being retried is used rather than going out directly.
+func (pool *Pool) Pick() *Proxy {
+	if pool == nil {
+		return nil
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	now := time.Now()
+	var fallback *Proxy
+	for tried := 0; tried < len(pool.proxies); tried++ {
+		index := (pool.current + tried) % len(pool.proxies)
+		proxy := pool.proxies[index]
+		if now.After(proxy.badUntil) {
+			pool.current = index
+			if pool.selection == SelectionRoundRobin {
+				pool.current++
+			}
+			return proxy
+		}
+		if fallback == nil || proxy.badUntil.Before(fallback.badUntil) {
+			fallback = proxy
+		}
+	}
+	return fallback
+}
+
+// Report records the outcome of a request made through proxy, a failed
+// proxy is skipped for a cooldown that grows with every failure in a row.
+func (pool *Pool) Report(proxy *Proxy, err error) {
+	if pool == nil || proxy == nil {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if err == nil {
+		proxy.failures = 0
+		proxy.badUntil = time.Time{}
+		metrics.Add("proxy_successes", pool.metricName(proxy), 1)
+		metrics.Set("proxy_healthy", pool.metricName(proxy), 1)
+		return
+	}
+
+	proxy.failures++
+	cooldown := proxyCooldown << (proxy.failures - 1)
+	if cooldown > proxyMaxCooldown || cooldown <= 0 {
+		cooldown = proxyMaxCooldown
+	}
+	proxy.badUntil = time.Now().Add(cooldown)
+	metrics.Add("proxy_failures", pool.metricName(proxy), 1)
+	metrics.Set("proxy_healthy", pool.metricName(proxy), 0)
+	log.Debugf("[%s] Marked proxy %s as bad for %.f seconds: %v", pool.name, proxy, cooldown.Seconds(), err)
+
+	// sticky pools move on from a proxy once it fails
+	if pool.selection == SelectionSticky && pool.proxies[pool.current%len(pool.proxies)] == proxy {
+		pool.current++
+	}
+}
+
+// IsBlocked reports whether a response means the proxy got turned away.
+func IsBlocked(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusForbidden || statusCode == http.StatusProxyAuthRequired
+}
+
+type poolTransport struct {
+	pool *Pool
+	base *http.Transport
+
+	mu         sync.Mutex
+	transports map[*Proxy]*http.Transport
+}
+
+// Transport returns a round tripper that sends every request through the
+// next proxy of the pool and reports how it went. Without proxies it's
+// just base.
+func (pool *Pool) Transport(base *http.Transport) http.RoundTripper {
+	if pool == nil {
+		return base
+	}
+	return &poolTransport{
+		pool:       pool,
+		base:       base,
+		transports: make(map[*Proxy]*http.Transport),
+	}
+}
+
+func (t *poolTransport) transport(proxy *Proxy) *http.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	transport, ok := t.transports[proxy]
+	if !ok {
+		transport = t.base.Clone()
+		transport.Proxy = http.ProxyURL(proxy.URL)
+		t.transports[proxy] = transport
+	}
+	return transport
+}
+
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy := t.pool.Pick()
+	resp, err := t.transport(proxy).RoundTrip(req)
+	switch {
+	case err != nil:
+		t.pool.Report(proxy, err)
+	case IsBlocked(resp.StatusCode):
+		t.pool.Report(proxy, fmt.Errorf("got status code %d", resp.StatusCode))
+	default:
+		t.pool.Report(proxy, nil)
+	}
+	return resp, err
+}
@@ -0,0 +1,222 @@
+package kick
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"strings"
+	"sync"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/DggHQ/dggarchiver-notifier/util"
+	http "github.com/bogdanfinn/fhttp"
+	tls_client "github.com/bogdanfinn/tls-client"
+	"golang.org/x/exp/slices"
+)
+
+var (
+	ErrChallenged       = errors.New("got a Cloudflare challenge")
+	ErrRateLimited      = errors.New("got rate limited")
+	ErrNotFound         = errors.New("not found")
+	ErrUnexpectedStatus = errors.New("unexpected status code")
+)
+
+// Classes of responses from Kick.
+const (
+	responseOK          = "ok"
+	responseChallenged  = "challenged"
+	responseRateLimited = "rate_limited"
+	responseNotFound    = "not_found"
+	responseError       = "error"
+)
+
+// kickClient is a TLS client with one of the configured client profiles.
+type kickClient struct {
+	name      string
+	userAgent string
+	client    tls_client.HttpClient
+}
+
+var (
+	kickClients       []kickClient
+	proxies           *network.Pool
+	networkOptions    network.Options
+	currentClient     int
+	currentClientLock sync.Mutex
+)
+
+// userAgent returns a user agent matching the browser of the client profile,
+// so the headers don't give away a mismatched TLS fingerprint.
+func userAgent(profile string) string {
+	browser, version, _ := strings.Cut(profile, "_")
+	version = strings.ReplaceAll(version, "_", ".")
+	switch browser {
+	case "firefox":
+		return fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:%s.0) Gecko/20100101 Firefox/%s.0", version, version)
+	case "safari":
+		return fmt.Sprintf("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/%s Safari/605.1.15", version)
+	case "opera":
+		return fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36 OPR/%s.0.0.0", version)
+	default:
+		return fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", version)
+	}
+}
+
+func InitializeKickScraper(cfg *config.Config) {
+	proxies = cfg.Notifier.Platforms.Kick.Proxies.Pool
+	// the TLS clients can't use the shared client, only its settings
+	networkOptions = cfg.Notifier.Network.Options()
+
+	for _, profileName := range cfg.Notifier.Platforms.Kick.ClientProfiles {
+		profile, ok := tls_client.MappedTLSClients[profileName]
+		browser, _, _ := strings.Cut(profileName, "_")
+		if !ok || !slices.Contains([]string{"chrome", "firefox", "safari", "opera"}, browser) {
+			log.Fatalf("[Kick] [SCRAPER] Unsupported client profile %s, please use one of the chrome_*, firefox_*, safari_* or opera_* profiles", profileName)
+		}
+
+		client, err := tls_client.NewHttpClient(tls_client.NewNoopLogger(),
+			tls_client.WithTimeoutSeconds(cfg.Notifier.Network.Timeout),
+			tls_client.WithClientProfile(profile),
+			tls_client.WithNotFollowRedirects(),
+			tls_client.WithCookieJar(tls_client.NewCookieJar()),
+		)
+		if err != nil {
+			log.Fatalf("[Kick] [SCRAPER] Error while creating a TLS client: %s", err)
+		}
+		kickClients = append(kickClients, kickClient{
+			name:      profileName,
+			userAgent: userAgent(profileName),
+			client:    client,
+		})
+	}
+}
+
+// classifyResponse tells a usable response apart from the ways Kick and
+// Cloudflare turn requests away.
+func classifyResponse(resp *http.Response, body []byte) string {
+	challenge := resp.Header.Get("cf-mitigated") == "challenge" ||
+		bytes.Contains(body, []byte("challenge-platform")) ||
+		bytes.Contains(body, []byte("Just a moment..."))
+	trimmed := bytes.TrimSpace(body)
+	isJSON := len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+
+	switch {
+	case challenge:
+		return responseChallenged
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return responseRateLimited
+	case resp.StatusCode == http.StatusNotFound:
+		return responseNotFound
+	case resp.StatusCode == http.StatusOK && isJSON:
+		return responseOK
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusServiceUnavailable:
+		// an HTML page instead of JSON is a challenge without the usual markers
+		return responseChallenged
+	default:
+		return responseError
+	}
+}
+
+// fetch requests url with the current client, rotating to the next one when
+// it gets challenged, rate limited or fails. It gives up once every client
+// has been tried.
+func fetch(url string) ([]byte, error) {
+	currentClientLock.Lock()
+	defer currentClientLock.Unlock()
+
+	var lastErr error
+	for tried := 0; tried < len(kickClients); tried++ {
+		client := kickClients[currentClient%len(kickClients)]
+
+		body, class, err := fetchWithClient(client, url)
+		util.AddMetric("kick_responses", class, 1)
+		switch class {
+		case responseOK:
+			util.AddMetric("kick_clients_succeeded", client.name, 1)
+			return body, nil
+		case responseNotFound:
+			// another client won't find it either
+			return nil, ErrNotFound
+		}
+
+		log.Debugf("[Kick] [SCRAPER] %s with %s: %v, rotating to the next client", url, client.name, err)
+		lastErr = err
+		currentClient++
+	}
+
+	return nil, lastErr
+}
+
+// fetchWithClient requests url through the next proxy of the pool, the proxy
+// is only blamed when the request fails or gets blocked.
+func fetchWithClient(client kickClient, url string) ([]byte, string, error) {
+	proxy := proxies.Pick()
+	proxyURL := ""
+	if proxy != nil {
+		proxyURL = proxy.URL.String()
+	}
+	if client.client.GetProxy() != proxyURL {
+		if err := client.client.SetProxy(proxyURL); err != nil {
+			return nil, responseError, err
+		}
+	}
+
+	body, class, err := doFetch(client, url)
+	switch class {
+	case responseOK, responseNotFound:
+		proxies.Report(proxy, nil)
+	default:
+		proxies.Report(proxy, err)
+	}
+	return body, class, err
+}
+
+func doFetch(client kickClient, url string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, responseError, err
+	}
+	if err := network.Wait(context.Background(), req.URL.Hostname(), networkOptions); err != nil {
+		return nil, responseError, err
+	}
+
+	req.Header = http.Header{
+		"accept":          {"application/json, text/plain, */*"},
+		"accept-language": {"en-US,en;q=0.5"},
+		"user-agent":      {client.userAgent},
+		http.HeaderOrderKey: {
+			"accept",
+			"accept-language",
+			"user-agent",
+		},
+	}
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return nil, responseError, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, responseError, err
+	}
+
+	switch class := classifyResponse(resp, body); class {
+	case responseOK:
+		return body, class, nil
+	case responseChallenged:
+		return nil, class, util.WithClass(util.ErrorBlocked, ErrChallenged)
+	case responseRateLimited:
+		return nil, class, util.WithStatus(ErrRateLimited, resp.StatusCode, nethttp.Header(resp.Header))
+	case responseNotFound:
+		return nil, class, util.WithClass(util.ErrorParse, ErrNotFound)
+	default:
+		return nil, class, util.WithStatus(fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode), resp.StatusCode, nethttp.Header(resp.Header))
+	}
+}
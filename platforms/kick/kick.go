@@ -2,129 +2,208 @@ package kick
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"strings"
 	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
 	log "github.com/DggHQ/dggarchiver-logger"
 	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
 	"github.com/DggHQ/dggarchiver-notifier/util"
-	http "github.com/bogdanfinn/fhttp"
-	tls_client "github.com/bogdanfinn/tls-client"
 	lua "github.com/yuin/gopher-lua"
-	"golang.org/x/exp/slices"
 )
 
-var kickHTTPClient tls_client.HttpClient
+// endpoint is one of the Kick API endpoints the channel's stream can be
+// looked up through, parse turns its response into the v1 channel format.
+type endpoint struct {
+	name  string
+	url   string
+	parse func([]byte) (*API, error)
+}
+
+// polls in a row every endpoint answered with 404 in, only touched by the
+// scraper thread
+var channelNotFound util.NotFound
 
-func InitializeKickScraper(cfg *config.Config) {
-	var err error
+var endpoints = []endpoint{
+	{name: "v1_channel", url: "https://kick.com/api/v1/channels/%s", parse: parseChannel},
+	{name: "v2_channel", url: "https://kick.com/api/v2/channels/%s", parse: parseChannel},
+	{name: "v2_livestream", url: "https://kick.com/api/v2/channels/%s/livestream", parse: parseLivestream},
+}
 
-	jar := tls_client.NewCookieJar()
-	options := []tls_client.HttpClientOption{
-		tls_client.WithTimeoutSeconds(30),
-		tls_client.WithClientProfile(tls_client.Chrome_110),
-		tls_client.WithNotFollowRedirects(),
-		tls_client.WithCookieJar(jar),
+func parseChannel(body []byte) (*API, error) {
+	var stream API
+	if err := json.Unmarshal(body, &stream); err != nil {
+		return nil, err
 	}
+	return &stream, nil
+}
 
-	if cfg.Notifier.Platforms.Kick.ProxyURL != "" {
-		options = append(options, tls_client.WithProxyUrl(cfg.Notifier.Platforms.Kick.ProxyURL))
+// parseLivestream handles the livestream endpoint, which only knows about the
+// running stream and doesn't have the channel ID.
+func parseLivestream(body []byte) (*API, error) {
+	var resp struct {
+		Data *struct {
+			ID          int       `json:"id"`
+			Slug        string    `json:"slug"`
+			CreatedAt   string    `json:"created_at"`
+			Title       string    `json:"session_title"`
+			PlaybackURL string    `json:"playback_url"`
+			Language    string    `json:"language"`
+			IsMature    bool      `json:"is_mature"`
+			Viewers     int       `json:"viewers"`
+			Category    *Category `json:"category"`
+			Thumbnail   struct {
+				Src    string `json:"src"`
+				SrcSet string `json:"srcset"`
+			} `json:"thumbnail"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
 	}
 
-	kickHTTPClient, err = tls_client.NewHttpClient(tls_client.NewNoopLogger(), options...)
-	if err != nil {
-		log.Fatalf("[Kick] [SCRAPER] Error while creating a TLS client: %s", err)
+	stream := &API{}
+	if resp.Data != nil {
+		stream.URL = resp.Data.PlaybackURL
+		stream.Livestream.IsLive = true
+		stream.Livestream.ID = resp.Data.ID
+		stream.Livestream.Slug = resp.Data.Slug
+		stream.Livestream.CreatedAt = resp.Data.CreatedAt
+		stream.Livestream.Title = resp.Data.Title
+		stream.Livestream.Thumbnail.URL = resp.Data.Thumbnail.SrcSet
+		stream.Livestream.Thumbnail.Src = resp.Data.Thumbnail.Src
+		stream.Livestream.Language = resp.Data.Language
+		stream.Livestream.IsMature = resp.Data.IsMature
+		stream.Livestream.ViewerCount = resp.Data.Viewers
+		if resp.Data.Category != nil {
+			stream.Livestream.Categories = []Category{*resp.Data.Category}
+		}
 	}
+	return stream, nil
 }
 
-func ScrapeKickStream(cfg *config.Config) *API {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://kick.com/api/v1/channels/%s", cfg.Notifier.Platforms.Kick.Channel), nil)
-	if err != nil {
-		log.Fatalf("[Kick] [SCRAPER] Error creating a request: %s", err)
+// ScrapeKickStream looks the channel up through the first endpoint that
+// works, falling back to the next one when an endpoint is blocked for every
+// client, gone or returns something unexpected. Endpoints whose breaker is
+// open are skipped until it lets a probe through.
+func ScrapeKickStream(cfg *config.Config) (*API, error) {
+	var lastErr error
+	notFound := 0
+	for _, endpoint := range endpoints {
+		stream, err := tryEndpoint(cfg, endpoint)
+		switch {
+		case errors.Is(err, network.ErrBreakerOpen):
+			log.Debugf("[Kick] [SCRAPER] Skipping the %s endpoint: %s", endpoint.name, err)
+		case err != nil:
+			log.Errorf("[Kick] [SCRAPER] Error checking the %s endpoint: %s", endpoint.name, err)
+			if errors.Is(err, ErrNotFound) {
+				notFound++
+			}
+		default:
+			channelNotFound.Found()
+			return stream, nil
+		}
+		lastErr = err
 	}
+	if notFound == len(endpoints) {
+		return nil, channelNotFound.Missing(fmt.Errorf("channel %s doesn't exist: %w", cfg.Notifier.Platforms.Kick.Channel, ErrNotFound))
+	}
+	channelNotFound.Found()
+	return nil, lastErr
+}
 
-	req.Header = http.Header{
-		"accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
-		"accept-language": {"en-US,en;q=0.5"},
-		"user-agent":      {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36"},
-		http.HeaderOrderKey: {
-			"accept",
-			"accept-language",
-			"user-agent",
-		},
+// tryEndpoint looks the channel up through endpoint if its breaker allows
+// it, recording the outcome.
+func tryEndpoint(cfg *config.Config, endpoint endpoint) (*API, error) {
+	breaker := network.GetBreaker("kick_" + endpoint.name)
+	if err := breaker.Allow(); err != nil {
+		return nil, err
 	}
 
-	resp, err := kickHTTPClient.Do(req)
+	stream, err := scrapeEndpoint(cfg, endpoint)
+	if errors.Is(err, ErrNotFound) {
+		// a missing channel says nothing about the endpoint
+		breaker.Record(nil)
+	} else {
+		breaker.Record(err)
+	}
 	if err != nil {
-		log.Errorf("[Kick] [SCRAPER] Error making a request: %s", err)
-		return nil
+		return nil, err
 	}
+	util.AddMetric("kick_endpoints_succeeded", endpoint.name, 1)
+	return stream, nil
+}
 
-	defer resp.Body.Close()
-	bytes, err := io.ReadAll(resp.Body)
+func scrapeEndpoint(cfg *config.Config, endpoint endpoint) (*API, error) {
+	body, err := fetch(fmt.Sprintf(endpoint.url, cfg.Notifier.Platforms.Kick.Channel))
 	if err != nil {
-		log.Errorf("[Kick] [SCRAPER] Error reading the response: %s", err)
-		return nil
+		return nil, err
 	}
-	var stream API
-	err = json.Unmarshal(bytes, &stream)
+	stream, err := endpoint.parse(body)
 	if err != nil {
-		log.Errorf("[Kick] [SCRAPER] Error unmarshalling the response: %s", err)
-		return nil
+		return nil, util.WithClass(util.ErrorParse, fmt.Errorf("error unmarshalling the response: %w", err))
 	}
-	return &stream
+	return stream, nil
 }
 
 func LoopScrapedLivestream(cfg *config.Config, state *util.State, l *lua.LState) error {
-	stream := ScrapeKickStream(cfg)
-	if stream != nil && stream.Livestream.IsLive {
-		if !slices.Contains(state.SentVODs, fmt.Sprintf("kick:%d", stream.Livestream.ID)) {
-			if state.CheckPriority("Kick", cfg) {
-				log.Infof("[Kick] [SCRAPER] Found a currently running stream with ID %d", stream.Livestream.ID)
-				if cfg.Notifier.Plugins.Enabled {
-					util.LuaCallReceiveFunction(l, fmt.Sprintf("%d", stream.Livestream.ID))
-				}
-
-				vod := &dggarchivermodel.VOD{
-					Platform:    "kick",
-					Downloader:  cfg.Notifier.Platforms.Kick.Downloader,
-					ID:          fmt.Sprintf("%d", stream.Livestream.ID),
-					PlaybackURL: stream.URL,
-					Title:       stream.Livestream.Title,
-					StartTime:   time.Now().Format(time.RFC3339),
-					EndTime:     "",
-					Thumbnail:   strings.Split(strings.Split(stream.Livestream.Thumbnail.URL, ",")[0], " ")[0],
-				}
-
-				state.CurrentStreams.Kick = *vod
-
-				bytes, err := json.Marshal(vod)
-				if err != nil {
-					log.Fatalf("[Kick] [SCRAPER] Couldn't marshal VOD with ID %s into a JSON object: %v", vod.ID, err)
-				}
-
-				if err = cfg.NATS.NatsConnection.Publish(fmt.Sprintf("%s.job", cfg.NATS.Topic), bytes); err != nil {
-					log.Errorf("[Kick] [SCRAPER] Wasn't able to send message with VOD with ID %s: %v", vod.ID, err)
-					return nil
-				}
-
-				if cfg.Notifier.Plugins.Enabled {
-					util.LuaCallSendFunction(l, vod)
-				}
-				state.SentVODs = append(state.SentVODs, fmt.Sprintf("kick:%s", vod.ID))
-				state.Dump()
-			} else {
-				log.Infof("[Kick] [SCRAPER] Stream with ID %d is being streamed on a different platform, skipping", stream.Livestream.ID)
-			}
-		} else {
-			log.Infof("[Kick] [SCRAPER] Stream with ID %d was already sent", stream.Livestream.ID)
-		}
-	} else {
-		state.CurrentStreams.Kick = dggarchivermodel.VOD{}
+	stream, err := ScrapeKickStream(cfg)
+	if err != nil {
+		return err
+	}
+	if !stream.Livestream.IsLive {
+		state.SetCurrentStream("Kick", dggarchivermodel.VOD{})
 		log.Infof("[Kick] [SCRAPER] No stream found")
+		return nil
+	}
+
+	startTime, err := stream.StartTime()
+	if err != nil {
+		log.Errorf("[Kick] [SCRAPER] Couldn't parse the start time of stream with ID %d, using the current time: %v", stream.Livestream.ID, err)
+		startTime = time.Now()
+	}
+	job := &util.Job{
+		VOD: dggarchivermodel.VOD{
+			Platform:    "kick",
+			Downloader:  cfg.Notifier.Platforms.Kick.Downloader,
+			ID:          fmt.Sprintf("%d", stream.Livestream.ID),
+			PlaybackURL: stream.URL,
+			Title:       stream.Livestream.Title,
+			StartTime:   startTime.UTC().Format(time.RFC3339),
+			EndTime:     "",
+			Thumbnail:   stream.Thumbnail(),
+		},
+		Metadata:  stream.Metadata(),
+		Detection: util.DetectionScraper,
+	}
+	if stream.URL != "" {
+		job.Manifest = &util.Manifest{URL: stream.URL, Type: util.ManifestHLS}
 	}
-	return nil
+	vod := &job.VOD
+	state.SetCurrentStream("Kick", *vod)
+
+	if state.WasSent("kick", vod.ID) {
+		log.Infof("[Kick] [SCRAPER] Stream with ID %s was already sent", vod.ID)
+		return nil
+	}
+	if decision := state.Decide(cfg, "Kick", vod.ID); !decision.Archive {
+		state.Suppress(job, decision)
+		return nil
+	}
+	recheck := func() bool {
+		again, err := ScrapeKickStream(cfg)
+		return err == nil && again.Livestream.IsLive && again.Livestream.ID == stream.Livestream.ID
+	}
+	if !state.Confirm(cfg, "Kick", job, stream.URL, recheck) {
+		return nil
+	}
+
+	log.Infof("[Kick] [SCRAPER] Found a currently running stream with ID %s", vod.ID)
+	if cfg.Notifier.Plugins.Enabled {
+		util.LuaCallReceiveFunction(l, vod.ID)
+	}
+
+	return state.Publish(cfg, l, job, "[Kick] [SCRAPER]")
 }
@@ -1,63 +1,113 @@
 package kick
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
-	log "github.com/DggHQ/dggarchiver-logger"
-	"github.com/DggHQ/dggarchiver-notifier/util"
-	luaLibs "github.com/vadv/gopher-lua-libs"
-	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/exp/slices"
 )
 
+type Category struct {
+	Name string   `json:"name"`
+	Slug string   `json:"slug"`
+	Tags []string `json:"tags"`
+}
+
 type API struct {
+	ID         int    `json:"id"`
 	URL        string `json:"playback_url"`
 	Livestream struct {
-		IsLive    bool   `json:"is_live"`
-		ID        int    `json:"id"`
-		Slug      string `json:"slug"`
-		CreatedAt string `json:"created_at"`
-		Title     string `json:"session_title"`
-		Thumbnail struct {
+		IsLive      bool       `json:"is_live"`
+		ID          int        `json:"id"`
+		Slug        string     `json:"slug"`
+		CreatedAt   string     `json:"created_at"`
+		Title       string     `json:"session_title"`
+		Language    string     `json:"language"`
+		IsMature    bool       `json:"is_mature"`
+		ViewerCount int        `json:"viewer_count"`
+		Tags        []string   `json:"tags"`
+		Categories  []Category `json:"categories"`
+		Thumbnail   struct {
 			URL string `json:"responsive"`
+			Src string `json:"url"`
 		} `json:"thumbnail"`
 	} `json:"livestream"`
 }
 
-type loopKick func(*config.Config, *util.State, *lua.LState) error
+// StartTime parses created_at, which the v1 and v2 channel endpoints return
+// as a UTC timestamp without a timezone and the livestream endpoint as RFC3339.
+func (stream *API) StartTime() (time.Time, error) {
+	if startTime, err := time.Parse(time.RFC3339, stream.Livestream.CreatedAt); err == nil {
+		return startTime, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", stream.Livestream.CreatedAt, time.UTC)
+}
+
+// Thumbnail returns the largest thumbnail in the responsive srcset, or the
+// plain thumbnail URL if there's no srcset.
+func (stream *API) Thumbnail() string {
+	if thumbnail := largestSrc(stream.Livestream.Thumbnail.URL); thumbnail != "" {
+		return thumbnail
+	}
+	return stream.Livestream.Thumbnail.Src
+}
+
+// Metadata returns the details of the stream that don't fit into a VOD.
+func (stream *API) Metadata() map[string]interface{} {
+	metadata := map[string]interface{}{
+		"slug":    stream.Livestream.Slug,
+		"mature":  stream.Livestream.IsMature,
+		"viewers": stream.Livestream.ViewerCount,
+	}
+	if stream.Livestream.Language != "" {
+		metadata["language"] = stream.Livestream.Language
+	}
 
-func StartKickThread(prefix string, f loopKick, cfg *config.Config, state *util.State, sleeptime time.Duration) {
-	go func() {
-		L := lua.NewState()
-		defer L.Close()
-		if cfg.Notifier.Plugins.Enabled {
-			luaLibs.Preload(L)
-			if err := L.DoFile(cfg.Notifier.Plugins.PathToPlugin); err != nil {
-				log.Fatalf("Wasn't able to load the Lua script: %s", err)
+	tags := append([]string{}, stream.Livestream.Tags...)
+	categories := make([]string, 0, len(stream.Livestream.Categories))
+	for _, category := range stream.Livestream.Categories {
+		categories = append(categories, category.Name)
+		for _, tag := range category.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
 			}
 		}
+	}
+	if len(categories) > 0 {
+		metadata["category"] = categories[0]
+		metadata["categories"] = categories
+	}
+	if len(tags) > 0 {
+		metadata["tags"] = tags
+	}
 
-		timeout := 0
+	return metadata
+}
 
-		for {
-			if timeout > 0 {
-				log.Infof("%s Sleeping for %d seconds before starting...", prefix, timeout)
-				time.Sleep(time.Second * time.Duration(timeout))
-			}
-			err := f(cfg, state, L)
+// largestSrc returns the URL with the largest width (or pixel density)
+// descriptor in a srcset.
+func largestSrc(srcset string) string {
+	var largest string
+	var largestSize float64
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		// a candidate without a descriptor counts as 1x
+		size := 1.0
+		if len(fields) > 1 {
+			descriptor := fields[len(fields)-1]
+			parsed, err := strconv.ParseFloat(strings.TrimRight(descriptor, "wx"), 64)
 			if err != nil {
-				log.Errorf("%s Got an error, will restart the loop: %v", prefix, err)
-				switch {
-				case timeout == 0:
-					timeout = 1
-				case (timeout >= 1 && timeout <= 32):
-					timeout *= 2
-				}
 				continue
 			}
-			timeout = 0
-			log.Infof("%s Sleeping for %.f minutes...", prefix, sleeptime.Minutes())
-			time.Sleep(sleeptime)
+			size = parsed
+		}
+		if largest == "" || size > largestSize {
+			largest, largestSize = fields[0], size
 		}
-	}()
+	}
+	return largest
 }
@@ -0,0 +1,200 @@
+package kick
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	pusherURL            = "wss://ws-%s.pusher.com/app/%s?protocol=7&client=js&version=8.3.0&flash=false"
+	pusherOrigin         = "https://kick.com"
+	pusherDefaultTimeout = 120
+
+	pusherConnected  = "pusher:connection_established"
+	pusherSubscribed = "pusher_internal:subscription_succeeded"
+	pusherError      = "pusher:error"
+	pusherPing       = "pusher:ping"
+	pusherPong       = "pusher:pong"
+	pusherSubscribe  = "pusher:subscribe"
+
+	eventStreamerIsLive      = `App\Events\StreamerIsLive`
+	eventStopStreamBroadcast = `App\Events\StopStreamBroadcast`
+
+	websocketMaxBackoff       = 5 * time.Minute
+	websocketStableConnection = time.Minute
+)
+
+var ErrNoChannelID = errors.New("couldn't get the channel ID from the Kick API")
+
+// kickTrigger wakes up the scraper loop before its sleep time runs out.
+var kickTrigger = make(chan struct{}, 1)
+
+type pusherMessage struct {
+	Event   string      `json:"event"`
+	Channel string      `json:"channel,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+type pusherConnection struct {
+	SocketID        string `json:"socket_id"`
+	ActivityTimeout int    `json:"activity_timeout"`
+}
+
+type pusherSubscription struct {
+	Auth    string `json:"auth"`
+	Channel string `json:"channel"`
+}
+
+// StartKickWebsocket listens to the channel's public Pusher events and
+// wakes up the scraper loop as soon as the stream starts or stops. The
+// scraper keeps polling at its usual interval as a safety net.
+func StartKickWebsocket(cfg *config.Config) {
+	go func() {
+		backoff := time.Second
+
+		for {
+			started := time.Now()
+			err := listenKickWebsocket(cfg)
+			if time.Since(started) > websocketStableConnection {
+				backoff = time.Second
+			}
+			log.Errorf("[Kick] [WEBSOCKET] Connection closed, reconnecting in %.f seconds: %v", backoff.Seconds(), err)
+			time.Sleep(backoff)
+			if backoff < websocketMaxBackoff {
+				backoff *= 2
+			}
+		}
+	}()
+}
+
+// Trigger is handed to the scheduler, so a websocket event wakes up the
+// scraper thread.
+func Trigger() <-chan struct{} {
+	return kickTrigger
+}
+
+func triggerKickScraper() {
+	select {
+	case kickTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// websocketChannelID is the ID of the channel the websocket subscribes to,
+// it's looked up once and only touched by the websocket goroutine.
+var websocketChannelID int
+
+// lookupChannelID returns the channel's ID from the first endpoint that has
+// it. It stays out of the scraper's bookkeeping, so a flapping websocket
+// can't make the scraper give up on the channel.
+func lookupChannelID(cfg *config.Config) (int, error) {
+	if websocketChannelID != 0 {
+		return websocketChannelID, nil
+	}
+
+	var lastErr error = ErrNoChannelID
+	for _, endpoint := range endpoints {
+		stream, err := tryEndpoint(cfg, endpoint)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if stream.ID != 0 {
+			websocketChannelID = stream.ID
+			return websocketChannelID, nil
+		}
+	}
+	return 0, lastErr
+}
+
+func listenKickWebsocket(cfg *config.Config) error {
+	channelID, err := lookupChannelID(cfg)
+	if err != nil {
+		return err
+	}
+	channel := fmt.Sprintf("channel.%d", channelID)
+
+	ws, err := websocket.Dial(fmt.Sprintf(pusherURL, cfg.Notifier.Platforms.Kick.Websocket.Cluster, cfg.Notifier.Platforms.Kick.Websocket.AppKey), "", pusherOrigin)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	activityTimeout := pusherDefaultTimeout
+	done := make(chan struct{})
+	defer close(done)
+
+	for {
+		if err := ws.SetReadDeadline(time.Now().Add(2 * time.Duration(activityTimeout) * time.Second)); err != nil {
+			return err
+		}
+
+		var raw []byte
+		if err := websocket.Message.Receive(ws, &raw); err != nil {
+			return err
+		}
+
+		var msg struct {
+			Event   string          `json:"event"`
+			Channel string          `json:"channel"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			log.Errorf("[Kick] [WEBSOCKET] Error unmarshalling a message: %s", err)
+			continue
+		}
+		// pusher double-encodes the payload of most events
+		var data string
+		if err := json.Unmarshal(msg.Data, &data); err != nil {
+			data = string(msg.Data)
+		}
+
+		switch msg.Event {
+		case pusherConnected:
+			var conn pusherConnection
+			if err := json.Unmarshal([]byte(data), &conn); err == nil && conn.ActivityTimeout > 0 {
+				activityTimeout = conn.ActivityTimeout
+			}
+			if err := websocket.JSON.Send(ws, pusherMessage{Event: pusherSubscribe, Data: pusherSubscription{Channel: channel}}); err != nil {
+				return err
+			}
+			go pingPusher(ws, time.Duration(activityTimeout)*time.Second/2, done)
+		case pusherSubscribed:
+			log.Infof("[Kick] [WEBSOCKET] Subscribed to %s", msg.Channel)
+			// events might've been missed while reconnecting
+			triggerKickScraper()
+		case pusherPing:
+			if err := websocket.JSON.Send(ws, pusherMessage{Event: pusherPong, Data: struct{}{}}); err != nil {
+				return err
+			}
+		case pusherError:
+			return fmt.Errorf("pusher error: %s", data)
+		case eventStreamerIsLive, eventStopStreamBroadcast:
+			log.Infof("[Kick] [WEBSOCKET] Got a %s event, checking the stream", msg.Event)
+			triggerKickScraper()
+		}
+	}
+}
+
+func pingPusher(ws *websocket.Conn, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := websocket.JSON.Send(ws, pusherMessage{Event: pusherPing, Data: struct{}{}}); err != nil {
+				log.Errorf("[Kick] [WEBSOCKET] Error sending a ping: %s", err)
+				return
+			}
+		}
+	}
+}
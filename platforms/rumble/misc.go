@@ -1,16 +1,28 @@
 package rumble
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
-	log "github.com/DggHQ/dggarchiver-logger"
-	"github.com/DggHQ/dggarchiver-notifier/util"
-	luaLibs "github.com/vadv/gopher-lua-libs"
-	lua "github.com/yuin/gopher-lua"
+	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
 )
 
+var (
+	ErrNoEmbedID          = errors.New("oEmbed HTML has no embed URL")
+	ErrNoPubDate          = errors.New("embed API response has no pubDate")
+	ErrUnexpectedStatus   = errors.New("unexpected status code")
+	ErrUnexpectedResponse = errors.New("unexpected response")
+	// the embed API answers with a plain false for videos it doesn't know
+	ErrUnknownVideo = errors.New("unknown video")
+)
+
+var embedIDRegexp = regexp.MustCompile(`^[0-9a-zA-Z]+$`)
+
 type OEmbed struct {
 	Title     string `json:"title"`
 	Duration  int    `json:"duration"`
@@ -18,62 +30,81 @@ type OEmbed struct {
 	HTML      string `json:"html"`
 }
 
-func (data OEmbed) EmbedID() string {
-	if data.HTML == "" {
-		return ""
+// EmbedID returns the ID from the embed URL in the oEmbed iframe HTML.
+func (data OEmbed) EmbedID() (string, error) {
+	_, rest, found := strings.Cut(data.HTML, "https://rumble.com/embed/")
+	if !found {
+		return "", ErrNoEmbedID
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	id, _, _ = strings.Cut(id, "\"")
+	if !embedIDRegexp.MatchString(id) {
+		return "", fmt.Errorf("%w: got %q", ErrNoEmbedID, id)
 	}
-	return strings.SplitN(strings.SplitN(data.HTML, "https://rumble.com/embed/", 2)[1], "/", 2)[0]
+	return id, nil
 }
 
+// API is the response of the embedJS video endpoint.
 type API struct {
+	Title   string `json:"title"`
 	PubDate string `json:"pubDate"`
+	// 0 for videos, 1 for upcoming and 2 for running livestreams
+	Live int `json:"live"`
+	// playable sources, their shape differs between videos and livestreams
+	// so they're only parsed when needed
+	U  json.RawMessage `json:"u"`
+	UA json.RawMessage `json:"ua"`
 }
 
-func (data API) StringToTime() *time.Time {
-	if data.PubDate == "" {
-		return nil
+// HLS returns the HLS playlist of the video, if the embed API has one.
+func (data API) HLS() string {
+	var u struct {
+		HLS struct {
+			URL string `json:"url"`
+		} `json:"hls"`
 	}
-	res, err := time.Parse(time.RFC3339, data.PubDate)
-	if err != nil {
-		return nil
+	if err := json.Unmarshal(data.U, &u); err == nil && u.HLS.URL != "" {
+		return u.HLS.URL
 	}
-	return &res
-}
-
-type loopRumble func(*config.Config, *util.State, *lua.LState) error
 
-func StartRumbleThread(prefix string, f loopRumble, cfg *config.Config, state *util.State, sleeptime time.Duration) {
-	go func() {
-		L := lua.NewState()
-		defer L.Close()
-		if cfg.Notifier.Plugins.Enabled {
-			luaLibs.Preload(L)
-			if err := L.DoFile(cfg.Notifier.Plugins.PathToPlugin); err != nil {
-				log.Fatalf("Wasn't able to load the Lua script: %s", err)
-			}
+	var ua struct {
+		HLS map[string]struct {
+			URL string `json:"url"`
+		} `json:"hls"`
+	}
+	if err := json.Unmarshal(data.UA, &ua); err != nil {
+		return ""
+	}
+	if auto, ok := ua.HLS["auto"]; ok && auto.URL != "" {
+		return auto.URL
+	}
+	qualities := make([]string, 0, len(ua.HLS))
+	for quality := range ua.HLS {
+		qualities = append(qualities, quality)
+	}
+	sort.Strings(qualities)
+	for _, quality := range qualities {
+		if ua.HLS[quality].URL != "" {
+			return ua.HLS[quality].URL
 		}
+	}
+	return ""
+}
+
+// Livestream is a running livestream found by the scraper.
+type Livestream struct {
+	dggarchivermodel.VOD
+	// HLS playlist from the embed API, if it had one
+	Manifest string
+}
 
-		timeout := 0
+func (data API) IsLive() bool {
+	return data.Live == 2
+}
 
-		for {
-			if timeout > 0 {
-				log.Infof("%s Sleeping for %d seconds before starting...", prefix, timeout)
-				time.Sleep(time.Second * time.Duration(timeout))
-			}
-			err := f(cfg, state, L)
-			if err != nil {
-				log.Errorf("%s Got an error, will restart the loop: %v", prefix, err)
-				switch {
-				case timeout == 0:
-					timeout = 1
-				case (timeout >= 1 && timeout <= 32):
-					timeout *= 2
-				}
-				continue
-			}
-			timeout = 0
-			log.Infof("%s Sleeping for %.f minutes...", prefix, sleeptime.Minutes())
-			time.Sleep(sleeptime)
-		}
-	}()
+func (data API) StringToTime() (time.Time, error) {
+	if data.PubDate == "" {
+		return time.Time{}, ErrNoPubDate
+	}
+	return time.Parse(time.RFC3339, data.PubDate)
 }
@@ -0,0 +1,179 @@
+package rumble
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The testdata responses follow the embedJS and oEmbed responses of a live
+// stream, a scheduled one and a video, with the IDs and URLs kept consistent
+// between them.
+var (
+	embedFixtures  = []string{"embed_live.json", "embed_upcoming.json", "embed_video.json"}
+	oEmbedFixtures = []string{"oembed_live.json", "oembed_video.json"}
+)
+
+func readFixture(tb testing.TB, name string, v interface{}) {
+	tb.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		tb.Fatalf("couldn't decode %s: %v", name, err)
+	}
+}
+
+func TestEmbedResponses(t *testing.T) {
+	tests := []struct {
+		fixture string
+		live    bool
+		pubDate time.Time
+		hls     string
+	}{
+		{fixture: "embed_live.json", live: true, pubDate: time.Date(2023, 6, 1, 18, 0, 12, 0, time.UTC), hls: "https://rumble.com/live-hls/6/v2j3bbc/playlist.m3u8"},
+		{fixture: "embed_upcoming.json", pubDate: time.Date(2023, 6, 2, 22, 0, 0, 0, time.UTC)},
+		{fixture: "embed_video.json", pubDate: time.Date(2023, 6, 1, 2, 41, 3, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			var data API
+			readFixture(t, test.fixture, &data)
+
+			if data.IsLive() != test.live {
+				t.Errorf("IsLive() = %v, want %v", data.IsLive(), test.live)
+			}
+			pubDate, err := data.StringToTime()
+			if err != nil {
+				t.Fatalf("StringToTime() failed: %v", err)
+			}
+			if !pubDate.Equal(test.pubDate) {
+				t.Errorf("StringToTime() = %v, want %v", pubDate, test.pubDate)
+			}
+			if hls := data.HLS(); hls != test.hls {
+				t.Errorf("HLS() = %q, want %q", hls, test.hls)
+			}
+		})
+	}
+}
+
+func TestOEmbedResponses(t *testing.T) {
+	tests := []struct {
+		fixture string
+		id      string
+		title   string
+	}{
+		{fixture: "oembed_live.json", id: "v2j3bbc", title: `Destiny Live – "Debates" & chat`},
+		{fixture: "oembed_video.json", id: "v2fq1xm", title: "Past stream"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			var data OEmbed
+			readFixture(t, test.fixture, &data)
+
+			id, err := data.EmbedID()
+			if err != nil {
+				t.Fatalf("EmbedID() failed: %v", err)
+			}
+			if id != test.id {
+				t.Errorf("EmbedID() = %q, want %q", id, test.id)
+			}
+			if data.Title != test.title {
+				t.Errorf("Title = %q, want %q", data.Title, test.title)
+			}
+		})
+	}
+}
+
+func FuzzEmbedID(f *testing.F) {
+	// html fields of oEmbed responses
+	for _, fixture := range oEmbedFixtures {
+		var data OEmbed
+		readFixture(f, fixture, &data)
+		f.Add(data.HTML)
+	}
+	f.Add(`<iframe src="https://rumble.com/embed/v2j3bbc/" width="640" height="360" frameborder="0" title="Destiny Live" webkitallowfullscreen mozallowfullscreen allowfullscreen></iframe>`)
+	f.Add(`<iframe src="https://rumble.com/embed/v2ktz4u/?pub=4" width="1920" height="1080" frameborder="0" title="" webkitallowfullscreen mozallowfullscreen allowfullscreen></iframe>`)
+	f.Add(`<iframe src="https://rumble.com/embed/v1ab2c3" width="640" height="360"></iframe>`)
+	f.Add(`<iframe src="https://rumble.com/embed/"></iframe>`)
+	f.Add(`https://rumble.com/embed/`)
+	f.Add(``)
+
+	f.Fuzz(func(t *testing.T, html string) {
+		id, err := OEmbed{HTML: html}.EmbedID()
+		if err != nil {
+			if !errors.Is(err, ErrNoEmbedID) {
+				t.Fatalf("error %v isn't ErrNoEmbedID", err)
+			}
+			if id != "" {
+				t.Fatalf("got ID %q with error %v", id, err)
+			}
+			return
+		}
+		if !embedIDRegexp.MatchString(id) {
+			t.Fatalf("got invalid ID %q", id)
+		}
+		if !strings.Contains(html, "https://rumble.com/embed/"+id) {
+			t.Fatalf("ID %q isn't in the embed URL of %q", id, html)
+		}
+	})
+}
+
+func FuzzStringToTime(f *testing.F) {
+	// pubDate fields of embedJS responses
+	for _, fixture := range embedFixtures {
+		var data API
+		readFixture(f, fixture, &data)
+		f.Add(data.PubDate)
+	}
+	f.Add("2023-06-01T18:00:12+00:00")
+	f.Add("2023-05-31T22:41:03-04:00")
+	f.Add("2023-06-01 18:00:12")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, pubDate string) {
+		startTime, err := API{PubDate: pubDate}.StringToTime()
+		if pubDate == "" {
+			if !errors.Is(err, ErrNoPubDate) {
+				t.Fatalf("got %v for an empty pubDate, want ErrNoPubDate", err)
+			}
+			return
+		}
+		if err != nil {
+			if !startTime.IsZero() {
+				t.Fatalf("got time %v with error %v", startTime, err)
+			}
+			return
+		}
+		if _, err := time.Parse(time.RFC3339, startTime.Format(time.RFC3339)); err != nil {
+			t.Fatalf("parsed %q into %v, which doesn't format back into RFC3339: %v", pubDate, startTime, err)
+		}
+	})
+}
+
+func FuzzHLS(f *testing.F) {
+	// u and ua fields of embedJS responses
+	for _, fixture := range embedFixtures {
+		var data API
+		readFixture(f, fixture, &data)
+		f.Add([]byte(data.U), []byte(data.UA))
+	}
+	f.Add([]byte(`{"hls":{"url":"https://rumble.com/live-hls/6/v2j3bbc/playlist.m3u8","meta":{"bitrate":0}}}`), []byte(`{"hls":{"auto":{"url":"https://rumble.com/live-hls/6/v2j3bbc/playlist.m3u8","meta":{"bitrate":0}}}}`))
+	f.Add([]byte(`{"mp4":{"url":"https://sp.rmbl.ws/s8/2/v/a/b/c.baa.mp4","meta":{"bitrate":648,"size":118283264,"w":854,"h":480}}}`), []byte(`{"mp4":{"480":{"url":"https://sp.rmbl.ws/s8/2/v/a/b/c.baa.mp4"}}}`))
+	f.Add([]byte(`[]`), []byte(`{"hls":{"720":{"url":"https://rumble.com/live-hls/6/v2j3bbc/720.m3u8"}}}`))
+	f.Add([]byte(``), []byte(`false`))
+
+	f.Fuzz(func(t *testing.T, u []byte, ua []byte) {
+		data := API{U: u, UA: ua}
+		if first, second := data.HLS(), data.HLS(); first != second {
+			t.Fatalf("got %q and then %q for the same response", first, second)
+		}
+	})
+}
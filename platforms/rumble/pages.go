@@ -0,0 +1,259 @@
+package rumble
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/DggHQ/dggarchiver-notifier/util"
+	"github.com/PuerkitoBio/goquery"
+)
+
+var ErrPageNotFound = errors.New("page not found")
+
+const oEmbedCacheTTL = time.Hour
+
+// Channel URL styles, rumble.com/c/<name> and rumble.com/user/<name>.
+const (
+	styleChannel = "c"
+	styleUser    = "user"
+)
+
+type cachedPage struct {
+	etag         string
+	lastModified string
+	links        []string
+}
+
+type cachedOEmbed struct {
+	data    *OEmbed
+	expires time.Time
+}
+
+// the caches, the request counter and the detected style are shared by the
+// scraper thread and the embed API calls of the confirmation rechecks
+var (
+	scraperMu    sync.Mutex
+	pageCache    = map[string]*cachedPage{}
+	oEmbedCache  = map[string]cachedOEmbed{}
+	pollRequests int64
+	// style that worked for a channel configured without one
+	detectedStyle string
+)
+
+// polls in a row both the channel and the live page were missing in, only
+// touched by the scraper thread
+var channelNotFound util.NotFound
+
+func getCachedOEmbed(link string) (*OEmbed, bool) {
+	scraperMu.Lock()
+	defer scraperMu.Unlock()
+	cached, ok := oEmbedCache[link]
+	if !ok || !time.Now().Before(cached.expires) {
+		return nil, false
+	}
+	return cached.data, true
+}
+
+// cacheOEmbed caches data for link, evicting the expired entries so the
+// cache doesn't outgrow the links of the last hour.
+func cacheOEmbed(link string, data *OEmbed) {
+	scraperMu.Lock()
+	defer scraperMu.Unlock()
+	now := time.Now()
+	for cachedLink, cached := range oEmbedCache {
+		if !now.Before(cached.expires) {
+			delete(oEmbedCache, cachedLink)
+		}
+	}
+	oEmbedCache[link] = cachedOEmbed{data: data, expires: now.Add(oEmbedCacheTTL)}
+}
+
+func getDetectedStyle() string {
+	scraperMu.Lock()
+	defer scraperMu.Unlock()
+	return detectedStyle
+}
+
+func setDetectedStyle(style string) {
+	scraperMu.Lock()
+	defer scraperMu.Unlock()
+	detectedStyle = style
+}
+
+// resetPollRequests starts counting the requests of a new poll, returning
+// the count of the previous one.
+func resetPollRequests() int64 {
+	scraperMu.Lock()
+	defer scraperMu.Unlock()
+	requests := pollRequests
+	pollRequests = 0
+	return requests
+}
+
+var rumbleHTTPClient = http.DefaultClient
+
+// InitializeRumbleScraper sets up the shared outbound client the scraper's
+// requests go through.
+func InitializeRumbleScraper(cfg *config.Config) {
+	rumbleHTTPClient = network.NewClient("rumble", cfg.Notifier.Platforms.Rumble.Proxies.Pool, cfg.Notifier.Network.Options())
+}
+
+// Circuit breakers of the endpoints the scraper depends on. The live page is
+// the alternate path of the channel page, so it goes without one.
+var (
+	channelPageBreaker = network.GetBreaker("rumble_channel_page")
+	oEmbedBreaker      = network.GetBreaker("rumble_oembed")
+)
+
+// isOutage reports whether err says something about the health of the
+// endpoint, missing pages, unknown videos and markup changes don't.
+func isOutage(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrPageNotFound), errors.Is(err, ErrUnknownVideo):
+		return false
+	default:
+		class := util.ErrorClass(err)
+		return class == util.ErrorTransient || class == util.ErrorRateLimited || class == util.ErrorBlocked
+	}
+}
+
+// withBreaker calls f if breaker allows it, only outages count as failures.
+func withBreaker(breaker *network.Breaker, f func() error) error {
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+	err := f()
+	if isOutage(err) {
+		breaker.Record(err)
+	} else {
+		breaker.Record(nil)
+	}
+	return err
+}
+
+func doRequest(req *http.Request) (*http.Response, error) {
+	scraperMu.Lock()
+	pollRequests++
+	scraperMu.Unlock()
+	util.AddMetric("rumble_requests", "total", 1)
+	return rumbleHTTPClient.Do(req)
+}
+
+// channelStyle splits the configured channel into its URL style and name.
+// The channel can be a bare name, c/<name>, user/<name> or a full URL, the
+// style is empty if it wasn't specified.
+func channelStyle(channel string) (string, string) {
+	channel = strings.TrimPrefix(strings.TrimPrefix(channel, "https://"), "http://")
+	channel = strings.TrimPrefix(strings.TrimPrefix(channel, "www."), "rumble.com")
+	channel = strings.Trim(channel, "/")
+
+	for _, style := range []string{styleChannel, styleUser} {
+		if strings.HasPrefix(channel, style+"/") {
+			return style, strings.TrimPrefix(channel, style+"/")
+		}
+	}
+	return "", channel
+}
+
+func channelURL(style string, name string) string {
+	return fmt.Sprintf("https://rumble.com/%s/%s", style, name)
+}
+
+func livePageURL(style string, name string) string {
+	if style == styleUser {
+		return fmt.Sprintf("https://rumble.com/user/%s/live", name)
+	}
+	return fmt.Sprintf("https://rumble.com/%s/live", name)
+}
+
+// fetchLinks fetches the page at pageURL and returns the links find picks
+// out of it. The page is requested conditionally, so an unchanged page
+// returns the links found the last time without being downloaded again.
+func fetchLinks(pageURL string, find func(*goquery.Document) []string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, pageURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	scraperMu.Lock()
+	cached := pageCache[pageURL]
+	scraperMu.Unlock()
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	response, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotModified && cached != nil:
+		util.AddMetric("rumble_requests", "not_modified", 1)
+		return cached.links, nil
+	case response.StatusCode == http.StatusNotFound:
+		return nil, util.WithClass(util.ErrorParse, ErrPageNotFound)
+	case response.StatusCode != http.StatusOK:
+		return nil, util.WithStatus(fmt.Errorf("%w: %d", ErrUnexpectedStatus, response.StatusCode), response.StatusCode, response.Header)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(response.Body)
+	if err != nil {
+		return nil, util.WithClass(util.ErrorParse, fmt.Errorf("%w: %s", ErrUnexpectedResponse, err))
+	}
+
+	links := find(doc)
+	scraperMu.Lock()
+	pageCache[pageURL] = &cachedPage{
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+		links:        links,
+	}
+	scraperMu.Unlock()
+	return links, nil
+}
+
+func absoluteLink(link string) string {
+	if strings.HasPrefix(link, "/") {
+		return fmt.Sprintf("https://rumble.com%s", link)
+	}
+	return link
+}
+
+// findListingLinks returns the videos with a live badge on a channel page.
+func findListingLinks(doc *goquery.Document) []string {
+	var links []string
+	doc.Find("a.video-item--a").Each(func(_ int, s *goquery.Selection) {
+		if live, _ := s.Find("span.video-item--live").Attr("data-value"); live != "" {
+			if link, ok := s.Attr("href"); ok {
+				links = append(links, absoluteLink(link))
+			}
+		}
+	})
+	return links
+}
+
+// findLivePageLinks returns the canonical link of the live page if it's
+// showing a running stream.
+func findLivePageLinks(doc *goquery.Document) []string {
+	if doc.Find(".watching-now").Length() == 0 {
+		return nil
+	}
+	if link, ok := doc.Find("link[rel=canonical]").Attr("href"); ok && link != "" {
+		return []string{absoluteLink(link)}
+	}
+	return nil
+}
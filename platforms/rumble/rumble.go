@@ -1,176 +1,260 @@
 package rumble
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
 	log "github.com/DggHQ/dggarchiver-logger"
 	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
 	"github.com/DggHQ/dggarchiver-notifier/util"
-	"github.com/gocolly/colly/v2"
 	lua "github.com/yuin/gopher-lua"
 	"golang.org/x/exp/slices"
 )
 
-func GetRumbleEmbedAPI(embedID string) *API {
-	response, err := http.Get(fmt.Sprintf("https://rumble.com/embedJS/u3/?request=video&ver=2&v=%s&ext={\"ad_count\":null}&ad_wt=0", embedID))
+func getJSON(apiURL string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, apiURL, http.NoBody)
 	if err != nil {
-		log.Errorf("[Rumble] [SCRAPER] HTTP error during the Rumble API check (%s): %s", embedID, err)
-		return nil
+		return err
+	}
+	response, err := doRequest(req)
+	if err != nil {
+		return err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != 200 {
-		log.Errorf("[Rumble] [SCRAPER] Status code != 200 for Rumble API, giving up.")
-		return nil
+	if response.StatusCode != http.StatusOK {
+		return util.WithStatus(fmt.Errorf("%w: %d", ErrUnexpectedStatus, response.StatusCode), response.StatusCode, response.Header)
 	}
 
-	bytes, err := io.ReadAll(response.Body)
+	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Errorf("[Rumble] [SCRAPER] Read error during the Rumble API check (%s): %s", embedID, err)
-		return nil
+		return err
 	}
 
-	data := &API{}
-	err = json.Unmarshal(bytes, data)
-	if err != nil {
-		log.Errorf("[Rumble] [SCRAPER] Unmarshalling error during the Rumble API check (%s): %s", embedID, err)
-		return nil
+	if string(bytes.TrimSpace(body)) == "false" {
+		return ErrUnknownVideo
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return util.WithClass(util.ErrorParse, fmt.Errorf("%w: %s", ErrUnexpectedResponse, err))
 	}
 
-	return data
+	return nil
+}
+
+func GetRumbleEmbedAPI(embedID string) (*API, error) {
+	data := &API{}
+	if err := getJSON(fmt.Sprintf("https://rumble.com/embedJS/u3/?request=video&ver=2&v=%s&ext={\"ad_count\":null}&ad_wt=0", url.QueryEscape(embedID)), data); err != nil {
+		return nil, fmt.Errorf("error during the Rumble API check (%s): %w", embedID, err)
+	}
+	return data, nil
 }
 
-func GetRumbleEmbed(url string) *OEmbed {
-	response, err := http.Get(fmt.Sprintf("https://rumble.com/api/Media/oembed.json/?url=%s", url))
+func GetRumbleEmbed(link string) (*OEmbed, error) {
+	if cached, ok := getCachedOEmbed(link); ok {
+		util.AddMetric("rumble_requests", "oembed_cache_hits", 1)
+		return cached, nil
+	}
+
+	data := &OEmbed{}
+	err := withBreaker(oEmbedBreaker, func() error {
+		return getJSON(fmt.Sprintf("https://rumble.com/api/Media/oembed.json/?url=%s", url.QueryEscape(link)), data)
+	})
 	if err != nil {
-		log.Errorf("[Rumble] [SCRAPER] HTTP error during the OEmbed check (%s): %s", url, err)
-		return nil
+		return nil, fmt.Errorf("error during the OEmbed check (%s): %w", link, err)
 	}
-	defer response.Body.Close()
+	cacheOEmbed(link, data)
+	return data, nil
+}
 
-	if response.StatusCode != 200 {
-		log.Errorf("[Rumble] [SCRAPER] Status code != 200 for OEmbed, giving up.")
-		return nil
+// getLivestream confirms that the video at link is live through the embed
+// API, returning nil if it isn't.
+func getLivestream(cfg *config.Config, link string) (*Livestream, error) {
+	embedData, err := GetRumbleEmbed(link)
+	if err != nil {
+		return nil, err
+	}
+	embedID, err := embedData.EmbedID()
+	if err != nil {
+		return nil, util.WithClass(util.ErrorParse, fmt.Errorf("error during the OEmbed check (%s): %w", link, err))
 	}
 
-	bytes, err := io.ReadAll(response.Body)
+	apiData, err := GetRumbleEmbedAPI(embedID)
+	if errors.Is(err, ErrUnknownVideo) {
+		log.Debugf("[Rumble] [SCRAPER] Video with ID %s is marked as live on the page, but the embed API doesn't know it, skipping", embedID)
+		return nil, nil
+	}
 	if err != nil {
-		log.Errorf("[Rumble] [SCRAPER] Read error during the OEmbed check (%s): %s", url, err)
-		return nil
+		return nil, err
+	}
+	if !apiData.IsLive() {
+		log.Debugf("[Rumble] [SCRAPER] Video with ID %s is marked as live on the page, but not in the embed API (%d), skipping", embedID, apiData.Live)
+		return nil, nil
 	}
 
-	data := &OEmbed{}
-	err = json.Unmarshal(bytes, data)
+	startTime, err := apiData.StringToTime()
 	if err != nil {
-		log.Errorf("[Rumble] [SCRAPER] Unmarshalling error during the OEmbed check (%s): %s", url, err)
-		return nil
+		log.Errorf("[Rumble] [SCRAPER] Couldn't get the start time of stream with ID %s, using the current time: %v", embedID, err)
+		startTime = time.Now()
+	}
+	title := embedData.Title
+	if title == "" {
+		title = apiData.Title
 	}
 
-	return data
+	return &Livestream{
+		VOD: dggarchivermodel.VOD{
+			Platform:    "rumble",
+			Downloader:  cfg.Notifier.Platforms.Rumble.Downloader,
+			ID:          embedID,
+			PlaybackURL: link,
+			Title:       title,
+			StartTime:   startTime.Format(time.RFC3339),
+			EndTime:     "",
+			Thumbnail:   embedData.Thumbnail,
+		},
+		Manifest: apiData.HLS(),
+	}, nil
 }
 
-func ScrapeRumblePage(cfg *config.Config) *dggarchivermodel.VOD {
-	var vod *dggarchivermodel.VOD
-	c1 := colly.NewCollector()
-	c1.DisableCookies()
-	c2 := colly.NewCollector()
-	c2.DisableCookies()
-
-	c1.OnHTML("a.video-item--a", func(h *colly.HTMLElement) {
-		if vod == nil {
-			live := h.ChildAttr("span.video-item--live", "data-value")
-			if len(live) != 0 {
-				link := h.Attr("href")
-				embedData := GetRumbleEmbed(link)
-				embedID := embedData.EmbedID()
-				vod = &dggarchivermodel.VOD{
-					Platform:    "rumble",
-					Downloader:  cfg.Notifier.Platforms.Rumble.Downloader,
-					ID:          embedID,
-					PlaybackURL: fmt.Sprintf("https://rumble.com%s", link),
-					Title:       embedData.Title,
-					StartTime:   time.Now().Format(time.RFC3339),
-					EndTime:     "",
-					Thumbnail:   embedData.Thumbnail,
-				}
+// ScrapeRumblePage looks for a running livestream on the channel page and
+// then on the live page, stopping at the first one the embed API confirms.
+func ScrapeRumblePage(cfg *config.Config) (*Livestream, error) {
+	resetPollRequests()
+	defer func() {
+		util.SetMetric("rumble_requests", "last_poll", resetPollRequests())
+	}()
+
+	style, name := channelStyle(cfg.Notifier.Platforms.Rumble.Channel)
+	styles := []string{style}
+	if style == "" {
+		// try the style that worked last time first
+		styles = []string{styleChannel, styleUser}
+		if getDetectedStyle() == styleUser {
+			styles = []string{styleUser, styleChannel}
+		}
+	}
+
+	var listing []string
+	err := withBreaker(channelPageBreaker, func() (err error) {
+		for _, style = range styles {
+			listing, err = fetchLinks(channelURL(style, name), findListingLinks)
+			if !errors.Is(err, ErrPageNotFound) {
+				break
 			}
 		}
+		return err
 	})
+	switch {
+	case errors.Is(err, network.ErrBreakerOpen):
+		// the live page only shows the main stream, but it's better than nothing
+		log.Infof("[Rumble] [SCRAPER] The channel page keeps failing, only checking the live page")
+		if style == "" {
+			style = getDetectedStyle()
+		}
+	case err != nil:
+		log.Errorf("[Rumble] [SCRAPER] Couldn't check the channel page: %v", err)
+	default:
+		setDetectedStyle(style)
+	}
 
-	c2.OnHTML("html", func(h *colly.HTMLElement) {
-		if vod == nil {
-			liveDOM := h.DOM.Find(".watching-now")
-			if len(liveDOM.Nodes) != 0 {
-				linkDOM := h.DOM.Find("link[rel=canonical]")
-				link, _ := linkDOM.Attr("href")
-				embedData := GetRumbleEmbed(link)
-				embedID := embedData.EmbedID()
-				vod = &dggarchivermodel.VOD{
-					Platform:    "rumble",
-					Downloader:  cfg.Notifier.Platforms.Rumble.Downloader,
-					ID:          embedID,
-					PlaybackURL: link,
-					Title:       embedData.Title,
-					StartTime:   time.Now().Format(time.RFC3339),
-					EndTime:     "",
-					Thumbnail:   embedData.Thumbnail,
-				}
+	checked := make([]string, 0, len(listing))
+	// a live badge alone isn't trusted, the first candidate the embed API
+	// confirms is the livestream
+	check := func(links []string) (*Livestream, error) {
+		var lastErr error
+		for _, link := range links {
+			if slices.Contains(checked, link) {
+				continue
+			}
+			checked = append(checked, link)
+			stream, err := getLivestream(cfg, link)
+			if err != nil {
+				log.Errorf("[Rumble] [SCRAPER] Couldn't check %s: %v", link, err)
+				lastErr = err
+				continue
+			}
+			if stream != nil {
+				return stream, nil
 			}
 		}
-	})
+		return nil, lastErr
+	}
 
-	_ = c1.Visit(fmt.Sprintf("https://rumble.com/c/%s?date=today", cfg.Notifier.Platforms.Rumble.Channel))
-	_ = c1.Visit(fmt.Sprintf("https://rumble.com/c/%s?date=this-week", cfg.Notifier.Platforms.Rumble.Channel))
-	_ = c1.Visit(fmt.Sprintf("https://rumble.com/c/%s?date=this-month", cfg.Notifier.Platforms.Rumble.Channel))
-	_ = c1.Visit(fmt.Sprintf("https://rumble.com/c/%s?date=this-year", cfg.Notifier.Platforms.Rumble.Channel))
-	_ = c1.Visit(fmt.Sprintf("https://rumble.com/c/%s", cfg.Notifier.Platforms.Rumble.Channel))
+	stream, checkErr := check(listing)
+	if stream != nil {
+		channelNotFound.Found()
+		return stream, nil
+	}
 
-	_ = c2.Visit(fmt.Sprintf("https://rumble.com/%s/live", cfg.Notifier.Platforms.Rumble.Channel))
+	live, liveErr := fetchLinks(livePageURL(style, name), findLivePageLinks)
+	if liveErr != nil && !errors.Is(liveErr, ErrPageNotFound) {
+		log.Errorf("[Rumble] [SCRAPER] Couldn't check the live page: %v", liveErr)
+	}
+	stream, liveCheckErr := check(live)
+	if stream != nil {
+		channelNotFound.Found()
+		return stream, nil
+	}
 
-	return vod
+	// only give up on the poll if neither page could be checked
+	if errors.Is(err, ErrPageNotFound) && errors.Is(liveErr, ErrPageNotFound) {
+		return nil, channelNotFound.Missing(fmt.Errorf("channel %s doesn't exist: %w", cfg.Notifier.Platforms.Rumble.Channel, err))
+	}
+	channelNotFound.Found()
+	if err != nil && liveErr != nil {
+		return nil, err
+	}
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	return nil, liveCheckErr
 }
 
 func LoopScrapedLivestream(cfg *config.Config, state *util.State, l *lua.LState) error {
-	vod := ScrapeRumblePage(cfg)
-	if vod != nil {
-		if !slices.Contains(state.SentVODs, fmt.Sprintf("rumble:%s", vod.ID)) {
-			if state.CheckPriority("Rumble", cfg) {
-				log.Infof("[Rumble] [SCRAPER] Found a currently running stream with ID %s", vod.ID)
-				if cfg.Notifier.Plugins.Enabled {
-					util.LuaCallReceiveFunction(l, vod.ID)
-				}
-
-				state.CurrentStreams.Rumble = *vod
-
-				bytes, err := json.Marshal(vod)
-				if err != nil {
-					log.Fatalf("[Rumble] [SCRAPER] Couldn't marshal VOD with ID %s into a JSON object: %v", vod.ID, err)
-				}
-
-				if err = cfg.NATS.NatsConnection.Publish(fmt.Sprintf("%s.job", cfg.NATS.Topic), bytes); err != nil {
-					log.Errorf("[Rumble] [SCRAPER] Wasn't able to send message with VOD with ID %s: %v", vod.ID, err)
-					return nil
-				}
-
-				if cfg.Notifier.Plugins.Enabled {
-					util.LuaCallSendFunction(l, vod)
-				}
-				state.SentVODs = append(state.SentVODs, fmt.Sprintf("rumble:%s", vod.ID))
-				state.Dump()
-			} else {
-				log.Infof("[Rumble] [SCRAPER] Stream with ID %s is being streamed on a different platform, skipping", vod.ID)
-			}
-		} else {
-			log.Infof("[Rumble] [SCRAPER] Stream with ID %s was already sent", vod.ID)
-		}
-	} else {
-		state.CurrentStreams.Rumble = dggarchivermodel.VOD{}
+	stream, err := ScrapeRumblePage(cfg)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		state.SetCurrentStream("Rumble", dggarchivermodel.VOD{})
 		log.Infof("[Rumble] [SCRAPER] No stream found")
+		return nil
 	}
-	return nil
+	vod := &stream.VOD
+	state.SetCurrentStream("Rumble", *vod)
+
+	if state.WasSent("rumble", vod.ID) {
+		log.Infof("[Rumble] [SCRAPER] Stream with ID %s was already sent", vod.ID)
+		return nil
+	}
+	job := &util.Job{VOD: *vod, Detection: util.DetectionScraper}
+	if stream.Manifest != "" {
+		job.Manifest = &util.Manifest{URL: stream.Manifest, Type: util.ManifestHLS}
+	}
+	if decision := state.Decide(cfg, "Rumble", vod.ID); !decision.Archive {
+		state.Suppress(job, decision)
+		return nil
+	}
+	recheck := func() bool {
+		apiData, err := GetRumbleEmbedAPI(vod.ID)
+		return err == nil && apiData.IsLive()
+	}
+	if !state.Confirm(cfg, "Rumble", job, stream.Manifest, recheck) {
+		return nil
+	}
+
+	log.Infof("[Rumble] [SCRAPER] Found a currently running stream with ID %s", vod.ID)
+	if cfg.Notifier.Plugins.Enabled {
+		util.LuaCallReceiveFunction(l, vod.ID)
+	}
+
+	return state.Publish(cfg, l, job, "[Rumble] [SCRAPER]")
 }
@@ -0,0 +1,139 @@
+package yt
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/DggHQ/dggarchiver-notifier/util"
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+var ErrQuotaExhausted = errors.New("quota of every API credential is exhausted")
+
+var (
+	currentService      int
+	currentServiceMutex sync.Mutex
+)
+
+func serviceNames(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.Notifier.Platforms.YouTube.Services))
+	for _, service := range cfg.Notifier.Platforms.YouTube.Services {
+		names = append(names, service.Name)
+	}
+	return names
+}
+
+func remainingQuota(cfg *config.Config, state *util.State) int {
+	return state.Quota.Remaining(cfg.Notifier.Platforms.YouTube.QuotaBudget, serviceNames(cfg))
+}
+
+func quotaInterval(cfg *config.Config, state *util.State, cost int) time.Duration {
+	return state.Quota.Interval(cfg.Notifier.Platforms.YouTube.QuotaBudget, serviceNames(cfg), cost)
+}
+
+func isQuotaExceeded(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 403 {
+		return false
+	}
+	return slices.ContainsFunc(apiErr.Errors, func(item googleapi.ErrorItem) bool {
+		return item.Reason == "quotaExceeded" || item.Reason == "dailyLimitExceeded"
+	})
+}
+
+// apiBreaker returns the circuit breaker of an API method, such as
+// youtube_search for search.list.
+func apiBreaker(method string) *network.Breaker {
+	return network.GetBreaker("youtube_" + strings.TrimSuffix(method, ".list"))
+}
+
+// isAPIOutage reports whether err says something about the health of the API
+// itself, rather than about the credentials or the request.
+func isAPIOutage(err error) bool {
+	if err == nil || googleapi.IsNotModified(err) || errors.Is(err, ErrQuotaExhausted) {
+		return false
+	}
+	switch util.ErrorClass(err) {
+	case util.ErrorTransient, util.ErrorRateLimited, util.ErrorBlocked:
+		return true
+	default:
+		return false
+	}
+}
+
+// callAPI runs call with the current API credentials, rotating to the next
+// ones that haven't been exhausted when the quota of the current ones is
+// exceeded. Calls are refused while the breaker of the method is open, and
+// run concurrently otherwise.
+func callAPI(cfg *config.Config, state *util.State, method string, cost int, call func(*youtube.Service) error) (err error) {
+	breaker := apiBreaker(method)
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+	defer func() {
+		if isAPIOutage(err) {
+			breaker.Record(err)
+		} else {
+			breaker.Record(nil)
+		}
+	}()
+
+	services := cfg.Notifier.Platforms.YouTube.Services
+
+	for tried := 0; tried < len(services); tried++ {
+		// the lock only covers picking the credentials, calls run concurrently
+		currentServiceMutex.Lock()
+		index := currentService % len(services)
+		service := services[index]
+		if state.Quota.IsExhausted(service.Name) {
+			currentService++
+			currentServiceMutex.Unlock()
+			continue
+		}
+		state.Quota.Spend(service.Name, method, cost)
+		currentServiceMutex.Unlock()
+
+		err := call(service.Service)
+		if isQuotaExceeded(err) {
+			log.Errorf("[YT] [API] Quota of %s is exceeded, rotating to the next credentials", service.Name)
+			state.Quota.Exhaust(service.Name)
+			currentServiceMutex.Lock()
+			// another call might have rotated past them already
+			if currentService%len(services) == index {
+				currentService++
+			}
+			currentServiceMutex.Unlock()
+			continue
+		}
+
+		log.Debugf("[YT] [API] %s was served by %s", method, service.Name)
+		util.AddMetric("youtube_api_calls_by_key", service.Name, 1)
+		return classifyAPIError(err)
+	}
+
+	return util.WithClass(util.ErrorAuth, ErrQuotaExhausted)
+}
+
+// classifyAPIError attaches a class to API errors by their status code. Not
+// modified responses are left alone, googleapi.IsNotModified doesn't look
+// through wrapped errors.
+func classifyAPIError(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || googleapi.IsNotModified(err) {
+		return err
+	}
+	class := util.StatusClass(apiErr.Code)
+	if apiErr.Code == http.StatusForbidden {
+		// the API answers with 403 for bad keys and disabled APIs, it doesn't block
+		class = util.ErrorAuth
+	}
+	return util.WithClass(class, err)
+}
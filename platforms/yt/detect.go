@@ -0,0 +1,207 @@
+package yt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var ErrConsentWall = errors.New("got a consent wall instead of the page")
+
+// Reasons reported by DetectLivestream.
+const (
+	ReasonLiveNow       = "isLiveNow"
+	ReasonIsLive        = "videoDetails.isLive"
+	ReasonLiveBadge     = "LIVE badge"
+	ReasonConsentWall   = "consent wall"
+	ReasonUnplayable    = "unplayable"
+	ReasonUpcoming      = "upcoming"
+	ReasonEnded         = "ended"
+	ReasonNotLivestream = "not a livestream"
+	ReasonNoLivestream  = "no livestream on the page"
+)
+
+// Detection is the result of checking a YouTube page for a livestream,
+// Reason explains why it was considered live or not.
+type Detection struct {
+	Live   bool
+	Reason string
+	Player *PlayerResponse
+	// IDs of the videos with a LIVE badge, found in ytInitialData
+	LiveVideoIDs []string
+}
+
+// DetectLivestream decides whether the page at pageURL shows a running
+// livestream. It only relies on the structured data embedded in the page,
+// so it doesn't depend on the language the page is served in.
+func DetectLivestream(pageURL string, body string) *Detection {
+	if u, err := url.Parse(pageURL); err == nil && strings.HasPrefix(u.Host, "consent.") {
+		return &Detection{Reason: ReasonConsentWall}
+	}
+
+	player, err := ParsePlayerResponse(body)
+	if err == nil && player.VideoDetails.VideoID != "" {
+		return detectPlayer(player)
+	}
+
+	var initialData orderedJSON
+	if extractInitialJSON(body, "ytInitialData", &initialData) {
+		if ids := findLiveVideos(initialData.Root); len(ids) > 0 {
+			return &Detection{Live: true, Reason: ReasonLiveBadge, LiveVideoIDs: ids}
+		}
+		return &Detection{Reason: ReasonNoLivestream}
+	}
+
+	if strings.Contains(body, "consent.youtube.com") {
+		return &Detection{Reason: ReasonConsentWall}
+	}
+
+	return &Detection{Reason: ReasonNoLivestream}
+}
+
+func detectPlayer(player *PlayerResponse) *Detection {
+	details := player.Microformat.PlayerMicroformatRenderer.LiveBroadcastDetails
+	switch {
+	case details != nil && details.EndTimestamp != "":
+		return &Detection{Reason: ReasonEnded, Player: player}
+	case details != nil && details.IsLiveNow:
+		return &Detection{Live: true, Reason: ReasonLiveNow, Player: player}
+	case player.VideoDetails.IsLive:
+		return &Detection{Live: true, Reason: ReasonIsLive, Player: player}
+	case player.VideoDetails.IsUpcoming || player.PlayabilityStatus.Status == "LIVE_STREAM_OFFLINE":
+		return &Detection{Reason: ReasonUpcoming, Player: player}
+	case player.PlayabilityStatus.Status != "" && player.PlayabilityStatus.Status != "OK":
+		return &Detection{Reason: ReasonUnplayable + ": " + player.PlayabilityStatus.Status, Player: player}
+	default:
+		return &Detection{Reason: ReasonNotLivestream, Player: player}
+	}
+}
+
+// jsonObject is a JSON object that keeps its members in document order, so
+// the videos of a page come out in the order the page shows them.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	Key   string
+	Value interface{}
+}
+
+func (object jsonObject) get(key string) interface{} {
+	for _, member := range object {
+		if member.Key == key {
+			return member.Value
+		}
+	}
+	return nil
+}
+
+// orderedJSON decodes any JSON value into jsonObjects, slices and scalars.
+type orderedJSON struct {
+	Root interface{}
+}
+
+func (ordered *orderedJSON) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	root, err := decodeOrdered(decoder)
+	if err != nil {
+		return err
+	}
+	ordered.Root = root
+	return nil
+}
+
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{Key: key.(string), Value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	default:
+		return token, nil
+	}
+}
+
+// findLiveVideos walks ytInitialData and returns the IDs of the video
+// renderers that carry a LIVE badge, in page order.
+func findLiveVideos(node interface{}) []string {
+	var ids []string
+
+	switch v := node.(type) {
+	case jsonObject:
+		if id, ok := v.get("videoId").(string); ok && hasLiveBadge(v) {
+			return []string{id}
+		}
+		for _, member := range v {
+			ids = appendUnique(ids, findLiveVideos(member.Value)...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			ids = appendUnique(ids, findLiveVideos(child)...)
+		}
+	}
+
+	return ids
+}
+
+func hasLiveBadge(node interface{}) bool {
+	switch v := node.(type) {
+	case jsonObject:
+		if renderer, ok := v.get("thumbnailOverlayTimeStatusRenderer").(jsonObject); ok && renderer.get("style") == "LIVE" {
+			return true
+		}
+		if renderer, ok := v.get("metadataBadgeRenderer").(jsonObject); ok && renderer.get("style") == "BADGE_STYLE_TYPE_LIVE_NOW" {
+			return true
+		}
+		for _, member := range v {
+			if hasLiveBadge(member.Value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if hasLiveBadge(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func appendUnique(ids []string, more ...string) []string {
+	for _, id := range more {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,83 @@
+package yt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// The live, upcoming, ended and channel_offline fixtures follow the layout of
+// the pages YouTube serves logged out visitors, trimmed to the parts the
+// detection reads: both inline JSON blobs, their script trailers and the
+// related videos, whose LIVE badges must not count for the watched video.
+// They're written by hand, so refresh them with trimmed captures, e.g.
+//
+//	curl -H "Cookie: SOCS=CAI" https://www.youtube.com/@destiny/live
+//
+// whenever YouTube changes the markup.
+func TestDetectLivestream(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		url     string
+		live    bool
+		reason  string
+		videoID string
+		liveIDs []string
+	}{
+		{name: "live", fixture: "live.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow/live", live: true, reason: ReasonLiveNow, videoID: "jNQXAC9IVRw"},
+		{name: "upcoming", fixture: "upcoming.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow/live", reason: ReasonUpcoming, videoID: "9bZkp7q19f0"},
+		{name: "ended", fixture: "ended.html", url: "https://www.youtube.com/watch?v=kJQP7kiw5Fk", reason: ReasonEnded, videoID: "kJQP7kiw5Fk"},
+		{name: "vod", fixture: "vod.html", url: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", reason: ReasonNotLivestream, videoID: "dQw4w9WgXcQ"},
+		{name: "consent redirect", fixture: "consent.html", url: "https://consent.youtube.com/m?continue=https://www.youtube.com/", reason: ReasonConsentWall},
+		{name: "consent form", fixture: "consent.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow/live", reason: ReasonConsentWall},
+		{name: "missing player response", fixture: "no_player.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow/live", reason: ReasonNoLivestream},
+		{name: "channel without live badge", fixture: "channel_offline.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow/streams", reason: ReasonNoLivestream},
+		{name: "channel with live badge", fixture: "channel_live_badge.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow/streams", live: true, reason: ReasonLiveBadge, liveIDs: []string{"jNQXAC9IVRw"}},
+		{name: "channel with two live badges", fixture: "channel_two_live.html", url: "https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow", live: true, reason: ReasonLiveBadge, liveIDs: []string{"jNQXAC9IVRw", "9bZkp7q19f0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			detection := DetectLivestream(test.url, string(body))
+			if detection.Live != test.live {
+				t.Errorf("Live = %v, want %v", detection.Live, test.live)
+			}
+			if detection.Reason != test.reason {
+				t.Errorf("Reason = %q, want %q", detection.Reason, test.reason)
+			}
+			var videoID string
+			if detection.Player != nil {
+				videoID = detection.Player.VideoDetails.VideoID
+			}
+			if videoID != test.videoID {
+				t.Errorf("video ID = %q, want %q", videoID, test.videoID)
+			}
+			if !slices.Equal(detection.LiveVideoIDs, test.liveIDs) {
+				t.Errorf("LiveVideoIDs = %v, want %v", detection.LiveVideoIDs, test.liveIDs)
+			}
+		})
+	}
+}
+
+func TestFindLiveVideosOrder(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "channel_two_live.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the primary stream is the first one, it has to be the same every poll
+	for i := 0; i < 20; i++ {
+		detection := DetectLivestream("https://www.youtube.com/channel/UCSJ4gkVC6NrvII8umztf0Ow", string(body))
+		if want := []string{"jNQXAC9IVRw", "9bZkp7q19f0"}; !slices.Equal(detection.LiveVideoIDs, want) {
+			t.Fatalf("LiveVideoIDs = %v, want %v", detection.LiveVideoIDs, want)
+		}
+	}
+}
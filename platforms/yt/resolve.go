@@ -0,0 +1,203 @@
+package yt
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/util"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+var (
+	ErrUnknownChannelReference = errors.New("not a channel ID, handle, channel URL or video URL")
+	ErrChannelNotFound         = errors.New("couldn't find the channel")
+)
+
+var (
+	channelIDRegexp = regexp.MustCompile(`^UC[\w-]{22}$`)
+	videoIDRegexp   = regexp.MustCompile(`^[\w-]{11}$`)
+)
+
+// Kinds of channel references ResolveChannelID understands.
+const (
+	referenceChannelID = "channel ID"
+	referenceHandle    = "handle"
+	referenceCustomURL = "custom URL"
+	referenceUser      = "username"
+	referenceVideo     = "video"
+)
+
+// parseChannelReference figures out what kind of reference to a channel the
+// input is, returning its kind along with the ID, handle or name in it.
+func parseChannelReference(input string) (string, string, error) {
+	input = strings.TrimSpace(input)
+	if channelIDRegexp.MatchString(input) {
+		return referenceChannelID, input, nil
+	}
+	if strings.HasPrefix(input, "@") {
+		return referenceHandle, input, nil
+	}
+
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownChannelReference, err)
+	}
+
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(u.Hostname(), "www."), "m."), "music.")
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+
+	switch {
+	case host == "youtu.be" && len(segments) > 0 && videoIDRegexp.MatchString(segments[0]):
+		return referenceVideo, segments[0], nil
+	case host != "youtube.com" || len(segments) == 0:
+		return "", "", ErrUnknownChannelReference
+	case segments[0] == "watch" && videoIDRegexp.MatchString(u.Query().Get("v")):
+		return referenceVideo, u.Query().Get("v"), nil
+	case (segments[0] == "live" || segments[0] == "shorts" || segments[0] == "embed") && len(segments) > 1 && videoIDRegexp.MatchString(segments[1]):
+		return referenceVideo, segments[1], nil
+	case segments[0] == "channel" && len(segments) > 1 && channelIDRegexp.MatchString(segments[1]):
+		return referenceChannelID, segments[1], nil
+	case strings.HasPrefix(segments[0], "@"):
+		return referenceHandle, segments[0], nil
+	case segments[0] == "c" && len(segments) > 1:
+		return referenceCustomURL, segments[1], nil
+	case segments[0] == "user" && len(segments) > 1:
+		return referenceUser, segments[1], nil
+	case len(segments) == 1 && segments[0] != "watch":
+		// legacy youtube.com/<name> custom URLs
+		return referenceCustomURL, segments[0], nil
+	}
+
+	return "", "", ErrUnknownChannelReference
+}
+
+// ResolveChannelID turns a channel ID, @handle, channel URL (/channel/,
+// /@handle, /c/, /user/ or a legacy custom URL) or a video URL into the
+// canonical UC... channel ID. The API is used if there are credentials with
+// quota left, otherwise (or if the API comes up empty) the pages are scraped.
+func ResolveChannelID(cfg *config.Config, state *util.State, input string) (string, error) {
+	kind, value, err := parseChannelReference(input)
+	if err != nil {
+		return "", WrapWithYTError(err, "RESOLVER", fmt.Sprintf("Couldn't parse %q", input))
+	}
+	if kind == referenceChannelID {
+		return value, nil
+	}
+
+	if len(cfg.Notifier.Platforms.YouTube.Services) > 0 && remainingQuota(cfg, state) >= util.QuotaChannelsList {
+		id, err := resolveWithAPI(cfg, state, kind, value)
+		if err == nil && id != "" {
+			return id, nil
+		}
+		log.Debugf("[YT] [RESOLVER] Couldn't resolve %s %s with the API, falling back to scraping: %v", kind, value, err)
+	}
+
+	id, err := resolveWithScraper(cfg, kind, value)
+	if err != nil {
+		return "", WrapWithYTError(err, "RESOLVER", fmt.Sprintf("Couldn't resolve %s %s", kind, value))
+	}
+	return id, nil
+}
+
+func resolveWithAPI(cfg *config.Config, state *util.State, kind string, value string) (string, error) {
+	switch kind {
+	case referenceVideo:
+		vid, _, err := GetVideoInfo(cfg, state, value, "")
+		if err != nil {
+			return "", err
+		}
+		if len(vid) > 0 && vid[0].Snippet != nil {
+			return vid[0].Snippet.ChannelId, nil
+		}
+	case referenceHandle, referenceUser:
+		var resp *youtube.ChannelListResponse
+		err := callAPI(cfg, state, "channels.list", util.QuotaChannelsList, func(service *youtube.Service) (err error) {
+			call := service.Channels.List([]string{"id"})
+			if kind == referenceUser {
+				resp, err = call.ForUsername(value).Do()
+				return err
+			}
+			// the client library predates handles, the parameter is passed as is
+			resp, err = call.Do(googleapi.QueryParameter("forHandle", value))
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(resp.Items) > 0 {
+			return resp.Items[0].Id, nil
+		}
+	}
+	// custom URLs can't be looked up through the API
+	return "", ErrChannelNotFound
+}
+
+func resolveWithScraper(cfg *config.Config, kind string, value string) (string, error) {
+	if kind == referenceVideo {
+		player, err := ScrapeVideo(cfg, value)
+		if err != nil {
+			return "", err
+		}
+		if !channelIDRegexp.MatchString(player.VideoDetails.ChannelID) {
+			return "", ErrChannelNotFound
+		}
+		return player.VideoDetails.ChannelID, nil
+	}
+
+	pageURL := fmt.Sprintf("https://www.youtube.com/%s?hl=en", url.PathEscape(value))
+	switch kind {
+	case referenceCustomURL:
+		pageURL = fmt.Sprintf("https://www.youtube.com/c/%s?hl=en", url.PathEscape(value))
+	case referenceUser:
+		pageURL = fmt.Sprintf("https://www.youtube.com/user/%s?hl=en", url.PathEscape(value))
+	}
+
+	body, finalURL, err := scrapePage(cfg, pageURL)
+	if err != nil {
+		return "", err
+	}
+	if u, err := url.Parse(finalURL); err == nil && strings.HasPrefix(u.Host, "consent.") {
+		return "", ErrConsentWall
+	}
+
+	var initialData struct {
+		Metadata struct {
+			ChannelMetadataRenderer struct {
+				ExternalID string `json:"externalId"`
+			} `json:"channelMetadataRenderer"`
+		} `json:"metadata"`
+	}
+	if !extractInitialJSON(body, "ytInitialData", &initialData) {
+		return "", ErrNoInitialData
+	}
+	id := initialData.Metadata.ChannelMetadataRenderer.ExternalID
+	if !channelIDRegexp.MatchString(id) {
+		return "", ErrChannelNotFound
+	}
+	return id, nil
+}
+
+// ResolveConfigChannel replaces the configured YouTube channel with its
+// channel ID if it's a handle or a URL.
+func ResolveConfigChannel(cfg *config.Config, state *util.State) {
+	channel := cfg.Notifier.Platforms.YouTube.Channel
+	if channelIDRegexp.MatchString(channel) {
+		return
+	}
+
+	id, err := ResolveChannelID(cfg, state, channel)
+	if err != nil {
+		log.Fatalf("Unable to resolve the notifier:platform:youtube:channel config variable to a channel ID: %s", err)
+	}
+	log.Infof("[YT] [RESOLVER] Resolved %s to channel ID %s", channel, id)
+	cfg.Notifier.Platforms.YouTube.Channel = id
+}
@@ -0,0 +1,270 @@
+package yt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/DggHQ/dggarchiver-notifier/util"
+	"github.com/gocolly/colly/v2"
+)
+
+var (
+	ErrNoPlayerResponse = errors.New("page has no ytInitialPlayerResponse")
+	ErrNoInitialData    = errors.New("page has no ytInitialData")
+)
+
+type Thumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// PlayerResponse contains the fields of ytInitialPlayerResponse the
+// notifier cares about.
+type PlayerResponse struct {
+	PlayabilityStatus struct {
+		Status            string `json:"status"`
+		LiveStreamability struct {
+			LiveStreamabilityRenderer struct {
+				OfflineSlate struct {
+					LiveStreamOfflineSlateRenderer struct {
+						ScheduledStartTime string `json:"scheduledStartTime"`
+					} `json:"liveStreamOfflineSlateRenderer"`
+				} `json:"offlineSlate"`
+			} `json:"liveStreamabilityRenderer"`
+		} `json:"liveStreamability"`
+	} `json:"playabilityStatus"`
+	VideoDetails struct {
+		VideoID    string `json:"videoId"`
+		ChannelID  string `json:"channelId"`
+		Title      string `json:"title"`
+		IsLive     bool   `json:"isLive"`
+		IsUpcoming bool   `json:"isUpcoming"`
+		// only set for livestreams
+		IsLiveDvrEnabled       bool   `json:"isLiveDvrEnabled"`
+		IsLowLatencyLiveStream bool   `json:"isLowLatencyLiveStream"`
+		LatencyClass           string `json:"latencyClass"`
+		Thumbnail              struct {
+			Thumbnails []Thumbnail `json:"thumbnails"`
+		} `json:"thumbnail"`
+	} `json:"videoDetails"`
+	StreamingData struct {
+		HLSManifestURL  string `json:"hlsManifestUrl"`
+		DASHManifestURL string `json:"dashManifestUrl"`
+		// the manifest URLs are signed and stop working after this
+		ExpiresInSeconds string `json:"expiresInSeconds"`
+	} `json:"streamingData"`
+	Microformat struct {
+		PlayerMicroformatRenderer struct {
+			PublishDate          string `json:"publishDate"`
+			LiveBroadcastDetails *struct {
+				IsLiveNow      bool   `json:"isLiveNow"`
+				StartTimestamp string `json:"startTimestamp"`
+				EndTimestamp   string `json:"endTimestamp"`
+			} `json:"liveBroadcastDetails"`
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+}
+
+// Metadata returns the attributes of the livestream downloader rules can
+// match on.
+func (player *PlayerResponse) Metadata() map[string]interface{} {
+	metadata := map[string]interface{}{
+		"dvr":         player.VideoDetails.IsLiveDvrEnabled,
+		"low_latency": player.VideoDetails.IsLowLatencyLiveStream,
+	}
+	if player.VideoDetails.LatencyClass != "" {
+		metadata["latency"] = strings.ToLower(strings.TrimPrefix(player.VideoDetails.LatencyClass, "MDE_STREAM_OPTIMIZATIONS_RENDERER_LATENCY_"))
+	}
+	return metadata
+}
+
+// Manifest returns the HLS manifest of the video, or the DASH one if it has
+// no HLS one, with the expiry the player response gave it.
+func (player *PlayerResponse) Manifest() *util.Manifest {
+	manifest := &util.Manifest{URL: player.StreamingData.HLSManifestURL, Type: util.ManifestHLS}
+	if manifest.URL == "" {
+		manifest = &util.Manifest{URL: player.StreamingData.DASHManifestURL, Type: util.ManifestDASH}
+	}
+	if manifest.URL == "" {
+		return nil
+	}
+	if seconds, err := strconv.Atoi(player.StreamingData.ExpiresInSeconds); err == nil && seconds > 0 {
+		manifest.Expires = time.Now().Add(time.Duration(seconds) * time.Second).UTC().Format(time.RFC3339)
+	}
+	return manifest
+}
+
+// IsLive reports whether the video is a currently running livestream.
+func (player *PlayerResponse) IsLive() bool {
+	return detectPlayer(player).Live
+}
+
+// ScheduledStartTime returns the RFC3339 scheduled start time of an
+// upcoming stream, or an empty string if it's unknown.
+func (player *PlayerResponse) ScheduledStartTime() string {
+	if details := player.Microformat.PlayerMicroformatRenderer.LiveBroadcastDetails; details != nil && !details.IsLiveNow && details.StartTimestamp != "" {
+		return details.StartTimestamp
+	}
+	slate := player.PlayabilityStatus.LiveStreamability.LiveStreamabilityRenderer.OfflineSlate.LiveStreamOfflineSlateRenderer
+	if unix, err := strconv.ParseInt(slate.ScheduledStartTime, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// Thumbnail returns the URL of the largest thumbnail.
+func (player *PlayerResponse) Thumbnail() string {
+	var largest Thumbnail
+	for _, thumbnail := range player.VideoDetails.Thumbnail.Thumbnails {
+		if thumbnail.Width*thumbnail.Height >= largest.Width*largest.Height {
+			largest = thumbnail
+		}
+	}
+	// drop the tracking query parameters
+	url, _, _ := strings.Cut(largest.URL, "?")
+	return url
+}
+
+func (player *PlayerResponse) VOD(cfg *config.Config) *dggarchivermodel.VOD {
+	vod := &dggarchivermodel.VOD{
+		Platform:   "youtube",
+		Downloader: cfg.Notifier.Platforms.YouTube.Downloader,
+		ID:         player.VideoDetails.VideoID,
+		PubTime:    player.Microformat.PlayerMicroformatRenderer.PublishDate,
+		Title:      player.VideoDetails.Title,
+		Thumbnail:  player.Thumbnail(),
+	}
+	if details := player.Microformat.PlayerMicroformatRenderer.LiveBroadcastDetails; details != nil {
+		vod.StartTime = details.StartTimestamp
+		vod.EndTime = details.EndTimestamp
+	}
+	return vod
+}
+
+// extractInitialJSON decodes the object assigned to the variable name in
+// one of the page's inline scripts into v.
+func extractInitialJSON(body string, name string, v interface{}) bool {
+	for _, prefix := range []string{fmt.Sprintf("var %s = ", name), fmt.Sprintf("%s = ", name), fmt.Sprintf("window[\"%s\"] = ", name)} {
+		index := strings.Index(body, prefix)
+		if index == -1 {
+			continue
+		}
+		if err := json.NewDecoder(strings.NewReader(body[index+len(prefix):])).Decode(v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePlayerResponse extracts ytInitialPlayerResponse from a YouTube page.
+func ParsePlayerResponse(body string) (*PlayerResponse, error) {
+	var player PlayerResponse
+	if !extractInitialJSON(body, "ytInitialPlayerResponse", &player) {
+		return nil, ErrNoPlayerResponse
+	}
+	return &player, nil
+}
+
+func scrapePage(cfg *config.Config, pageURL string) (string, string, error) {
+	var body, finalURL string
+	client := network.NewClient("youtube", cfg.Notifier.Platforms.YouTube.Proxies.Pool, cfg.Notifier.Network.Options())
+	c := colly.NewCollector(colly.UserAgent(cfg.Notifier.Network.UserAgent))
+	c.WithTransport(client.Transport)
+	c.SetRequestTimeout(client.Timeout)
+	// disable cookie handling and reject the consent screen up front
+	c.DisableCookies()
+
+	c.OnRequest(func(r *colly.Request) {
+		r.Headers.Set("Cookie", "SOCS=CAI; CONSENT=PENDING+999")
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		body = string(r.Body)
+		finalURL = r.Request.URL.String()
+	})
+
+	var statusCode int
+	var header http.Header
+	c.OnError(func(r *colly.Response, _ error) {
+		statusCode = r.StatusCode
+		if r.Headers != nil {
+			header = *r.Headers
+		}
+	})
+
+	if err := c.Visit(pageURL); err != nil {
+		if statusCode != 0 {
+			err = util.WithStatus(err, statusCode, header)
+		}
+		return "", "", WrapWithYTError(err, "SCRAPER", fmt.Sprintf("Error visiting %s", pageURL))
+	}
+
+	return body, finalURL, nil
+}
+
+// ScrapeLivestream checks the channel's live page for a running livestream.
+// The returned detection always has the player response of the livestream
+// if it's live.
+func ScrapeLivestream(cfg *config.Config) (*Detection, error) {
+	body, finalURL, err := scrapePage(cfg, fmt.Sprintf("https://www.youtube.com/channel/%s/live?hl=en", cfg.Notifier.Platforms.YouTube.Channel))
+	if err != nil {
+		return nil, err
+	}
+
+	detection := DetectLivestream(finalURL, body)
+	if detection.Live && detection.Player == nil {
+		player, err := ScrapeVideo(cfg, detection.LiveVideoIDs[0])
+		if err != nil {
+			return nil, err
+		}
+		detection.Player = player
+	}
+
+	return detection, nil
+}
+
+// ScrapeConcurrentLivestreams returns the IDs of the channel's running
+// livestreams other than primary, going by the LIVE badges on its streams tab.
+func ScrapeConcurrentLivestreams(cfg *config.Config, primary string) ([]string, error) {
+	body, _, err := scrapePage(cfg, fmt.Sprintf("https://www.youtube.com/channel/%s/streams?hl=en", cfg.Notifier.Platforms.YouTube.Channel))
+	if err != nil {
+		return nil, err
+	}
+
+	var initialData orderedJSON
+	if !extractInitialJSON(body, "ytInitialData", &initialData) {
+		return nil, WrapWithYTError(util.WithClass(util.ErrorParse, ErrNoInitialData), "SCRAPER", "Couldn't parse the streams tab")
+	}
+
+	var ids []string
+	for _, id := range findLiveVideos(initialData.Root) {
+		if id != primary {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ScrapeVideo returns the player response of the video with the given ID.
+func ScrapeVideo(cfg *config.Config, id string) (*PlayerResponse, error) {
+	body, _, err := scrapePage(cfg, fmt.Sprintf("https://www.youtube.com/watch?v=%s&hl=en", id))
+	if err != nil {
+		return nil, err
+	}
+
+	player, err := ParsePlayerResponse(body)
+	if err != nil {
+		return nil, WrapWithYTError(util.WithClass(util.ErrorParse, err), "SCRAPER", fmt.Sprintf("Couldn't parse the page of video %s", id))
+	}
+
+	return player, nil
+}
@@ -0,0 +1,371 @@
+package yt
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/DggHQ/dggarchiver-notifier/util"
+	luaLibs "github.com/vadv/gopher-lua-libs"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	webSubTopic = "https://www.youtube.com/xml/feeds/videos.xml?channel_id=%s"
+	// how many times a pushed video that hasn't started yet is checked again
+	webSubMaxRechecks = 5
+	webSubRecheckTime = time.Minute
+)
+
+var (
+	ErrWebSubSubscribe = errors.New("hub didn't accept the subscription request")
+	ErrWebSubSignature = errors.New("invalid signature")
+)
+
+type webSubFeed struct {
+	Entries []struct {
+		VideoID   string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+		ChannelID string `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+		Title     string `xml:"title"`
+	} `xml:"entry"`
+}
+
+type webSubVideo struct {
+	ID      string
+	Recheck int
+}
+
+type webSubSubscriber struct {
+	cfg    *config.Config
+	topic  string
+	leases chan int
+	videos chan webSubVideo
+	client *http.Client
+}
+
+// StartWebSubThread subscribes to the channel's feed on the WebSub hub and
+// handles the push notifications it sends to the configured callback URL.
+// The polling loops keep running as a fallback.
+func StartWebSubThread(cfg *config.Config, state *util.State) {
+	s := &webSubSubscriber{
+		cfg:    cfg,
+		topic:  fmt.Sprintf(webSubTopic, cfg.Notifier.Platforms.YouTube.Channel),
+		leases: make(chan int, 1),
+		videos: make(chan webSubVideo, 16),
+		client: network.NewClient("youtube_websub", nil, cfg.Notifier.Network.Options()),
+	}
+
+	http.Handle(cfg.Notifier.Platforms.YouTube.WebSub.CallbackPath, s)
+
+	go s.renew()
+	go s.process(state)
+}
+
+func (s *webSubSubscriber) subscribe() error {
+	websub := s.cfg.Notifier.Platforms.YouTube.WebSub
+	form := url.Values{
+		"hub.callback":      {websub.CallbackURL},
+		"hub.topic":         {s.topic},
+		"hub.mode":          {"subscribe"},
+		"hub.verify":        {"async"},
+		"hub.lease_seconds": {strconv.Itoa(websub.Lease)},
+	}
+	if websub.Secret != "" {
+		form.Set("hub.secret", websub.Secret)
+	}
+
+	resp, err := s.client.PostForm(websub.Hub, form)
+	if err != nil {
+		return WrapWithYTError(err, "WebSub", "Subscription request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return WrapWithYTError(ErrWebSubSubscribe, "WebSub", fmt.Sprintf("Hub returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body))))
+	}
+
+	return nil
+}
+
+// renew keeps the subscription alive, resubscribing before the lease
+// granted by the hub runs out.
+func (s *webSubSubscriber) renew() {
+	timeout := time.Minute
+
+	for {
+		if err := s.subscribe(); err != nil {
+			log.Errorf("[YT] [WEBSUB] %v, retrying in %.f minute(s)", err, timeout.Minutes())
+			time.Sleep(timeout)
+			if timeout < 30*time.Minute {
+				timeout *= 2
+			}
+			continue
+		}
+		log.Infof("[YT] [WEBSUB] Sent a subscription request for %s", s.topic)
+
+		select {
+		case lease := <-s.leases:
+			timeout = time.Minute
+			renewIn := time.Duration(lease) * time.Second * 9 / 10
+			log.Infof("[YT] [WEBSUB] Subscription verified for %d seconds, renewing in %.f minute(s)", lease, renewIn.Minutes())
+			time.Sleep(renewIn)
+		case <-time.After(10 * time.Minute):
+			log.Errorf("[YT] [WEBSUB] Hub didn't verify the subscription in time, is %s reachable? Retrying...", s.cfg.Notifier.Platforms.YouTube.WebSub.CallbackURL)
+		}
+	}
+}
+
+func (s *webSubSubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.verify(w, r)
+	case http.MethodPost:
+		s.notify(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *webSubSubscriber) verify(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("hub.topic") != s.topic {
+		log.Errorf("[YT] [WEBSUB] Got a verification request for an unknown topic: %s", query.Get("hub.topic"))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		lease, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil || lease <= 0 {
+			lease = s.cfg.Notifier.Platforms.YouTube.WebSub.Lease
+		}
+		select {
+		case s.leases <- lease:
+		default:
+		}
+	case "unsubscribe":
+		// the notifier never unsubscribes, anyone else asking for it is refused
+		log.Errorf("[YT] [WEBSUB] Hub is verifying an unsubscription from %s that wasn't requested, refusing it", s.topic)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case "denied":
+		log.Errorf("[YT] [WEBSUB] Hub denied the subscription: %s", query.Get("hub.reason"))
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(query.Get("hub.challenge")))
+}
+
+func (s *webSubSubscriber) notify(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		log.Errorf("[YT] [WEBSUB] Error reading the notification: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the hub expects a 2xx even for notifications that fail verification
+	w.WriteHeader(http.StatusNoContent)
+
+	if secret := s.cfg.Notifier.Platforms.YouTube.WebSub.Secret; secret != "" {
+		if err := verifySignature(r.Header.Get("X-Hub-Signature"), secret, body); err != nil {
+			log.Errorf("[YT] [WEBSUB] Ignoring a notification: %s", err)
+			return
+		}
+	}
+
+	var feed webSubFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		log.Errorf("[YT] [WEBSUB] Error unmarshalling the notification: %s", err)
+		return
+	}
+
+	for _, entry := range feed.Entries {
+		if entry.VideoID == "" || entry.ChannelID != s.cfg.Notifier.Platforms.YouTube.Channel {
+			continue
+		}
+		log.Infof("[YT] [WEBSUB] Got a notification for video %s (%s)", entry.VideoID, entry.Title)
+		s.queue(webSubVideo{ID: entry.VideoID})
+	}
+}
+
+func (s *webSubSubscriber) queue(video webSubVideo) {
+	select {
+	case s.videos <- video:
+	default:
+		log.Errorf("[YT] [WEBSUB] Notification queue is full, dropping video %s", video.ID)
+	}
+}
+
+func verifySignature(header string, secret string, body []byte) error {
+	algo, signature, found := strings.Cut(header, "=")
+	if !found {
+		return WrapWithYTError(ErrWebSubSignature, "WebSub", "Missing X-Hub-Signature header")
+	}
+
+	var h func() hash.Hash
+	switch algo {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return WrapWithYTError(ErrWebSubSignature, "WebSub", fmt.Sprintf("Unsupported signature algorithm %q", algo))
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return WrapWithYTError(ErrWebSubSignature, "WebSub", "Malformed X-Hub-Signature header")
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return WrapWithYTError(ErrWebSubSignature, "WebSub", "Signature mismatch")
+	}
+
+	return nil
+}
+
+func (s *webSubSubscriber) process(state *util.State) {
+	L := lua.NewState()
+	defer L.Close()
+	if s.cfg.Notifier.Plugins.Enabled {
+		luaLibs.Preload(L)
+		if err := L.DoFile(s.cfg.Notifier.Plugins.PathToPlugin); err != nil {
+			log.Fatalf("Wasn't able to load the Lua script: %s", err)
+		}
+	}
+
+	for video := range s.videos {
+		if err := s.checkVideo(state, L, video); err != nil {
+			log.Errorf("[YT] [WEBSUB] Error while checking video %s: %v", video.ID, err)
+		}
+	}
+}
+
+// checkVideo confirms that a pushed video is a running livestream before
+// sending it. Videos that are created right before going live are checked
+// again a few times.
+func (s *webSubSubscriber) checkVideo(state *util.State, l *lua.LState, video webSubVideo) error {
+	if state.WasSent("youtube", video.ID) {
+		log.Infof("[YT] [WEBSUB] Stream with ID %s was already sent", video.ID)
+		return nil
+	}
+
+	vod, err := s.lookupVideo(state, video.ID)
+	if err != nil {
+		return err
+	}
+
+	primary := state.CurrentStream("YouTube")
+	switch {
+	case vod == nil:
+		log.Infof("[YT] [WEBSUB] Video %s is not a livestream, skipping", video.ID)
+	case vod.EndTime != "":
+		log.Infof("[YT] [WEBSUB] Stream with ID %s has already ended, skipping", video.ID)
+	case vod.StartTime == "":
+		if video.Recheck >= webSubMaxRechecks {
+			log.Infof("[YT] [WEBSUB] Stream with ID %s hasn't started yet, leaving it to the polling loops", video.ID)
+			return nil
+		}
+		log.Infof("[YT] [WEBSUB] Stream with ID %s hasn't started yet, checking again in %.f minute(s)", video.ID, webSubRecheckTime.Minutes())
+		video.Recheck++
+		time.AfterFunc(webSubRecheckTime, func() {
+			s.queue(video)
+		})
+	case primary.ID != "" && primary.ID != vod.ID && !s.cfg.Notifier.Platforms.YouTube.ArchiveSecondary:
+		log.Infof("[YT] [WEBSUB] Stream with ID %s is running concurrently with %s, skipping it", video.ID, primary.ID)
+	default:
+		if decision := state.Decide(s.cfg, "YouTube", video.ID); !decision.Archive {
+			state.Suppress(&util.Job{VOD: *vod, Detection: util.DetectionWebSub}, decision)
+			return nil
+		}
+		log.Infof("[YT] [WEBSUB] Found a currently running stream with ID %s", video.ID)
+		if s.cfg.Notifier.Plugins.Enabled {
+			util.LuaCallReceiveFunction(l, video.ID)
+		}
+		if primary.ID == "" {
+			state.SetCurrentStream("YouTube", *vod)
+		}
+		return sendVOD(s.cfg, state, l, vod, nil, util.DetectionWebSub, "[YT] [WEBSUB]")
+	}
+
+	return nil
+}
+
+// lookupVideo returns the pushed video as a VOD with an empty StartTime if
+// it hasn't started yet, or nil if it isn't a livestream. The API is only
+// used while there's quota left and its breaker is closed, the watch page is
+// scraped otherwise.
+func (s *webSubSubscriber) lookupVideo(state *util.State, id string) (*dggarchivermodel.VOD, error) {
+	if remainingQuota(s.cfg, state) >= util.QuotaVideosList && !apiBreaker("videos.list").IsOpen() {
+		vid, _, err := GetVideoInfo(s.cfg, state, id, "")
+		if err == nil || errors.Is(err, ErrIsNotModified) {
+			if len(vid) == 0 || vid[0].LiveStreamingDetails == nil {
+				return nil, nil
+			}
+			vod := videoToVOD(s.cfg, vid[0])
+			if vod.StartTime == "" {
+				s.addScheduled(state, vod, vid[0].LiveStreamingDetails.ScheduledStartTime)
+			}
+			return vod, nil
+		}
+		log.Errorf("[YT] [WEBSUB] Couldn't get the API info for video %s, scraping it instead: %v", id, err)
+	}
+
+	player, err := ScrapeVideo(s.cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	if player.Microformat.PlayerMicroformatRenderer.LiveBroadcastDetails == nil {
+		return nil, nil
+	}
+	vod := player.VOD(s.cfg)
+	// the start timestamp of an upcoming stream is the scheduled one
+	if !player.IsLive() && vod.EndTime == "" {
+		vod.StartTime = ""
+		s.addScheduled(state, vod, player.ScheduledStartTime())
+	}
+	return vod, nil
+}
+
+func (s *webSubSubscriber) addScheduled(state *util.State, vod *dggarchivermodel.VOD, scheduledStartTime string) {
+	if scheduledStartTime == "" {
+		return
+	}
+	state.AddScheduled(s.cfg, util.ScheduledStream{
+		Platform:           "youtube",
+		ID:                 vod.ID,
+		Title:              vod.Title,
+		Thumbnail:          vod.Thumbnail,
+		ScheduledStartTime: scheduledStartTime,
+	})
+}
@@ -1,46 +1,26 @@
 package yt
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
+	"sort"
 	"strings"
+	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
 	log "github.com/DggHQ/dggarchiver-logger"
 	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
 	"github.com/DggHQ/dggarchiver-notifier/util"
-	"github.com/gocolly/colly/v2"
 	lua "github.com/yuin/gopher-lua"
-	"golang.org/x/exp/slices"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/youtube/v3"
 )
 
-func ScrapeLivestreamID(cfg *config.Config) string {
-	var index int
-	var id string
-	c := colly.NewCollector()
-	// disable cookie handling to bypass youtube consent screen
-	c.DisableCookies()
-
-	c.OnResponse(func(r *colly.Response) {
-		index = strings.Index(string(r.Body), "Started streaming ")
-	})
-
-	c.OnHTML("link[href][rel='canonical']", func(h *colly.HTMLElement) {
-		if index != -1 {
-			id = ytRegexp.FindStringSubmatch(h.Attr("href"))[1]
-		}
+func GetLivestreamID(cfg *config.Config, state *util.State, etag string) ([]*youtube.Video, string, error) {
+	var resp *youtube.SearchListResponse
+	err := callAPI(cfg, state, "search.list", util.QuotaSearchList, func(service *youtube.Service) (err error) {
+		resp, err = service.Search.List([]string{"snippet"}).IfNoneMatch(etag).EventType("live").ChannelId(cfg.Notifier.Platforms.YouTube.Channel).Type("video").Do()
+		return err
 	})
-
-	_ = c.Visit(fmt.Sprintf("https://youtube.com/channel/%s/live?hl=en", cfg.Notifier.Platforms.YouTube.Channel))
-
-	return id
-}
-
-func GetLivestreamID(cfg *config.Config, etag string) ([]*youtube.Video, string, error) {
-	resp, err := cfg.Notifier.Platforms.YouTube.Service.Search.List([]string{"snippet"}).IfNoneMatch(etag).EventType("live").ChannelId(cfg.Notifier.Platforms.YouTube.Channel).Type("video").Do()
 	if err != nil {
 		if !googleapi.IsNotModified(err) {
 			return nil, etag, WrapWithYTError(err, "API", "Youtube API error")
@@ -48,19 +28,28 @@ func GetLivestreamID(cfg *config.Config, etag string) ([]*youtube.Video, string,
 		return nil, etag, WrapWithYTError(ErrIsNotModified, "API", "Got a 304 Not Modified for livestream ID, returning an empty slice")
 	}
 
-	if len(resp.Items) > 0 {
-		id, _, err := GetVideoInfo(cfg, resp.Items[0].Id.VideoId, "")
+	ids := make([]string, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		ids = append(ids, item.Id.VideoId)
+	}
+	if len(ids) > 0 {
+		// one videos.list call covers all the concurrent streams
+		vids, _, err := GetVideoInfo(cfg, state, strings.Join(ids, ","), "")
 		if err != nil && !errors.Is(err, ErrIsNotModified) {
-			return id, resp.Etag, nil
+			return nil, etag, err
 		}
-		return id, resp.Etag, nil
+		return vids, resp.Etag, nil
 	}
 
 	return nil, resp.Etag, nil
 }
 
-func GetVideoInfo(cfg *config.Config, id string, etag string) ([]*youtube.Video, string, error) {
-	resp, err := cfg.Notifier.Platforms.YouTube.Service.Videos.List([]string{"snippet", "liveStreamingDetails"}).IfNoneMatch(etag).Id(id).Do()
+func GetVideoInfo(cfg *config.Config, state *util.State, id string, etag string) ([]*youtube.Video, string, error) {
+	var resp *youtube.VideoListResponse
+	err := callAPI(cfg, state, "videos.list", util.QuotaVideosList, func(service *youtube.Service) (err error) {
+		resp, err = service.Videos.List([]string{"snippet", "liveStreamingDetails"}).IfNoneMatch(etag).Id(id).Do()
+		return err
+	})
 	if err != nil {
 		if !googleapi.IsNotModified(err) {
 			return nil, etag, WrapWithYTError(err, "", "Youtube API error")
@@ -71,8 +60,12 @@ func GetVideoInfo(cfg *config.Config, id string, etag string) ([]*youtube.Video,
 	return resp.Items, resp.Etag, nil
 }
 
-func GetLivestreamInfo(cfg *config.Config, id string, etag string) ([]*youtube.Video, string, error) {
-	resp, err := cfg.Notifier.Platforms.YouTube.Service.Videos.List([]string{"liveStreamingDetails"}).IfNoneMatch(etag).Id(id).Do()
+func GetLivestreamInfo(cfg *config.Config, state *util.State, id string, etag string) ([]*youtube.Video, string, error) {
+	var resp *youtube.VideoListResponse
+	err := callAPI(cfg, state, "videos.list", util.QuotaVideosList, func(service *youtube.Service) (err error) {
+		resp, err = service.Videos.List([]string{"liveStreamingDetails"}).IfNoneMatch(etag).Id(id).Do()
+		return err
+	})
 	if err != nil {
 		if !googleapi.IsNotModified(err) {
 			return nil, etag, WrapWithYTError(err, "", "Youtube API error")
@@ -83,103 +76,263 @@ func GetLivestreamInfo(cfg *config.Config, id string, etag string) ([]*youtube.V
 	return resp.Items, resp.Etag, nil
 }
 
+// lastAPICheck is used to stretch the API loop when the quota budget runs low
+var lastAPICheck time.Time
+
 func LoopAPILivestream(cfg *config.Config, state *util.State, l *lua.LState) error {
-	vid, etagEnd, err := GetLivestreamID(cfg, state.SearchETag)
-	if err != nil && !errors.Is(err, ErrIsNotModified) {
+	// around a scheduled stream the loop gets woken up more often, only
+	// the scheduled videos are checked then since it's a lot cheaper
+	for _, scheduled := range state.ScheduledStreamsDue("youtube") {
+		if remainingQuota(cfg, state) < util.QuotaVideosList || apiBreaker("videos.list").IsOpen() {
+			break
+		}
+		vid, _, err := GetVideoInfo(cfg, state, scheduled.ID, "")
+		if err != nil && !errors.Is(err, ErrIsNotModified) {
+			return err
+		}
+		if len(vid) > 0 && vid[0].LiveStreamingDetails != nil && vid[0].LiveStreamingDetails.ActualStartTime != "" && vid[0].LiveStreamingDetails.ActualEndTime == "" {
+			log.Infof("[YT] [API] Scheduled stream with ID %s has started", scheduled.ID)
+			if err := sendScheduledLivestream(cfg, state, l, videoToVOD(cfg, vid[0])); err != nil {
+				return err
+			}
+		}
+	}
+	if time.Since(lastAPICheck) < time.Duration(cfg.Notifier.Platforms.YouTube.APIRefresh)*time.Minute {
+		return nil
+	}
+
+	cost := util.QuotaSearchList + util.QuotaVideosList
+	if remaining := remainingQuota(cfg, state); remaining < cost {
+		log.Infof("[YT] [API] Quota budget is exhausted (%d units left), relying on the scraper until the quota resets", remaining)
+		return nil
+	}
+	if apiBreaker("search.list").IsOpen() {
+		log.Infof("[YT] [API] The search API keeps failing, relying on the scraper until it recovers")
+		return nil
+	}
+	interval := quotaInterval(cfg, state, cost)
+	if interval > time.Duration(cfg.Notifier.Platforms.YouTube.APIRefresh)*time.Minute && time.Since(lastAPICheck) < interval {
+		log.Infof("[YT] [API] Quota budget is running low, stretching API checks to every %.f minutes", interval.Minutes())
+		return nil
+	}
+	lastAPICheck = time.Now()
+
+	vids, etagEnd, err := GetLivestreamID(cfg, state, state.SearchETag)
+	if errors.Is(err, ErrIsNotModified) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 	state.SearchETag = etagEnd
 	state.Dump()
-	if len(vid) > 0 && !slices.Contains(state.SentVODs, fmt.Sprintf("youtube:%s", vid[0].Id)) {
-		log.Infof("[YT] [API] Found a currently running stream with ID %s", vid[0].Id)
+	if len(vids) == 0 {
+		state.SetCurrentStream("YouTube", dggarchivermodel.VOD{})
+		log.Infof("[YT] [API] No stream found")
+		return nil
+	}
+
+	// the stream that started first is the primary one
+	sort.SliceStable(vids, func(i, j int) bool {
+		return vids[i].LiveStreamingDetails != nil && vids[j].LiveStreamingDetails != nil &&
+			vids[i].LiveStreamingDetails.ActualStartTime < vids[j].LiveStreamingDetails.ActualStartTime
+	})
+	state.SetCurrentStream("YouTube", *videoToVOD(cfg, vids[0]))
+
+	for i, vid := range vids {
+		if i > 0 && !cfg.Notifier.Platforms.YouTube.ArchiveSecondary {
+			log.Infof("[YT] [API] Stream with ID %s is running concurrently with %s, skipping it", vid.Id, vids[0].Id)
+			continue
+		}
+		if state.WasSent("youtube", vid.Id) {
+			log.Infof("[YT] [API] Stream with ID %s was already sent", vid.Id)
+			continue
+		}
+		vod := videoToVOD(cfg, vid)
+		if decision := state.Decide(cfg, "YouTube", vid.Id); !decision.Archive {
+			state.Suppress(&util.Job{VOD: *vod, Detection: util.DetectionAPI}, decision)
+			continue
+		}
+		log.Infof("[YT] [API] Found a currently running stream with ID %s", vid.Id)
 		if cfg.Notifier.Plugins.Enabled {
-			util.LuaCallReceiveFunction(l, vid[0].Id)
+			util.LuaCallReceiveFunction(l, vid.Id)
 		}
-		vod := &dggarchivermodel.VOD{
-			Platform:  "youtube",
-			ID:        vid[0].Id,
-			PubTime:   vid[0].Snippet.PublishedAt,
-			Title:     vid[0].Snippet.Title,
-			StartTime: vid[0].LiveStreamingDetails.ActualStartTime,
-			EndTime:   vid[0].LiveStreamingDetails.ActualEndTime,
-			Thumbnail: vid[0].Snippet.Thumbnails.Medium.Url,
+		if err := sendVOD(cfg, state, l, vod, nil, util.DetectionAPI, "[YT] [API]"); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		state.CurrentStreams.YouTube = *vod
-
-		bytes, err := json.Marshal(vod)
-		if err != nil {
-			log.Fatalf("[YT] [API] Couldn't marshal VOD with ID %s into a JSON object: %v", vod.ID, err)
+func LoopScrapedLivestream(cfg *config.Config, state *util.State, l *lua.LState) error {
+	detection, err := ScrapeLivestream(cfg)
+	if err != nil {
+		return err
+	}
+	if detection.Reason == ReasonConsentWall {
+		return WrapWithYTError(util.WithClass(util.ErrorBlocked, ErrConsentWall), "SCRAPER", "Couldn't check the live page")
+	}
+	if detection.Live {
+		primary := detection.Player
+		state.SetCurrentStream("YouTube", *primary.VOD(cfg))
+		if err := sendScrapedLivestream(cfg, state, l, primary, detection.Reason); err != nil {
+			return err
 		}
 
-		if err = cfg.NATS.NatsConnection.Publish(fmt.Sprintf("%s.job", cfg.NATS.Topic), bytes); err != nil {
-			log.Errorf("[YT] [API] Wasn't able to send message with VOD with ID %s: %v", vod.ID, err)
+		secondary, err := ScrapeConcurrentLivestreams(cfg, primary.VideoDetails.VideoID)
+		if err != nil {
+			log.Errorf("[YT] [SCRAPER] Couldn't check for concurrent streams: %v", err)
 			return nil
 		}
-
-		if cfg.Notifier.Plugins.Enabled {
-			util.LuaCallSendFunction(l, vod)
+		for _, id := range secondary {
+			if !cfg.Notifier.Platforms.YouTube.ArchiveSecondary {
+				log.Infof("[YT] [SCRAPER] Stream with ID %s is running concurrently with %s, skipping it", id, primary.VideoDetails.VideoID)
+				continue
+			}
+			if state.WasSent("youtube", id) {
+				log.Infof("[YT] [SCRAPER] Stream with ID %s was already sent", id)
+				continue
+			}
+			player, err := ScrapeVideo(cfg, id)
+			if err != nil {
+				log.Errorf("[YT] [SCRAPER] Couldn't scrape concurrent stream with ID %s: %v", id, err)
+				continue
+			}
+			if player.IsLive() {
+				if err := sendScrapedLivestream(cfg, state, l, player, ReasonLiveBadge); err != nil {
+					return err
+				}
+			}
 		}
-		state.SentVODs = append(state.SentVODs, fmt.Sprintf("youtube:%s", vod.ID))
-		state.Dump()
 	} else {
-		state.CurrentStreams.YouTube = dggarchivermodel.VOD{}
-		log.Infof("[YT] [API] No stream found")
+		state.SetCurrentStream("YouTube", dggarchivermodel.VOD{})
+		log.Infof("[YT] [SCRAPER] No stream found (%s)", detection.Reason)
+		if detection.Reason == ReasonUpcoming {
+			if scheduled := detection.Player.ScheduledStartTime(); scheduled != "" {
+				state.AddScheduled(cfg, util.ScheduledStream{
+					Platform:           "youtube",
+					ID:                 detection.Player.VideoDetails.VideoID,
+					Title:              detection.Player.VideoDetails.Title,
+					Thumbnail:          detection.Player.Thumbnail(),
+					ScheduledStartTime: scheduled,
+				})
+			}
+		}
 	}
 	return nil
 }
 
-func LoopScrapedLivestream(cfg *config.Config, state *util.State, l *lua.LState) error {
-	id := ScrapeLivestreamID(cfg)
-	if id != "" {
-		if !slices.Contains(state.SentVODs, fmt.Sprintf("youtube:%s", id)) {
-			if state.CheckPriority("YouTube", cfg) {
-				log.Infof("[YT] [SCRAPER] Found a currently running stream with ID %s", id)
-				if cfg.Notifier.Plugins.Enabled {
-					util.LuaCallReceiveFunction(l, id)
-				}
-				vid, _, err := GetVideoInfo(cfg, id, "")
-				if err != nil && !errors.Is(err, ErrIsNotModified) {
-					return err
-				}
+func sendScrapedLivestream(cfg *config.Config, state *util.State, l *lua.LState, player *PlayerResponse, reason string) error {
+	id := player.VideoDetails.VideoID
+	if state.WasSent("youtube", id) {
+		log.Infof("[YT] [SCRAPER] Stream with ID %s was already sent", id)
+		return nil
+	}
+	if decision := state.Decide(cfg, "YouTube", id); !decision.Archive {
+		state.Suppress(&util.Job{VOD: *player.VOD(cfg), Detection: util.DetectionScraper}, decision)
+		return nil
+	}
+	recheck := func() bool {
+		again, err := ScrapeVideo(cfg, id)
+		return err == nil && again.IsLive()
+	}
+	if !state.Confirm(cfg, "YouTube", &util.Job{VOD: *player.VOD(cfg)}, player.StreamingData.HLSManifestURL, recheck) {
+		return nil
+	}
 
-				vod := &dggarchivermodel.VOD{
-					Platform:   "youtube",
-					Downloader: cfg.Notifier.Platforms.YouTube.Downloader,
-					ID:         vid[0].Id,
-					PubTime:    vid[0].Snippet.PublishedAt,
-					Title:      vid[0].Snippet.Title,
-					StartTime:  vid[0].LiveStreamingDetails.ActualStartTime,
-					EndTime:    vid[0].LiveStreamingDetails.ActualEndTime,
-					Thumbnail:  vid[0].Snippet.Thumbnails.Medium.Url,
-				}
+	log.Infof("[YT] [SCRAPER] Found a currently running stream with ID %s (%s)", id, reason)
+	if cfg.Notifier.Plugins.Enabled {
+		util.LuaCallReceiveFunction(l, id)
+	}
+
+	vod := player.VOD(cfg)
+	// only spend quota if the page was missing something
+	if (vod.Title == "" || vod.StartTime == "") && remainingQuota(cfg, state) >= util.QuotaVideosList && !apiBreaker("videos.list").IsOpen() {
+		vid, _, err := GetVideoInfo(cfg, state, id, "")
+		if err != nil && !errors.Is(err, ErrIsNotModified) {
+			log.Errorf("[YT] [SCRAPER] Couldn't get the API info for stream with ID %s, sending the scraped info: %v", id, err)
+		}
+		if len(vid) > 0 && vid[0].LiveStreamingDetails != nil {
+			vod = videoToVOD(cfg, vid[0])
+		}
+	}
+	if vod.StartTime == "" {
+		vod.StartTime = time.Now().Format(time.RFC3339)
+	}
 
-				state.CurrentStreams.YouTube = *vod
+	return sendVOD(cfg, state, l, vod, player, util.DetectionScraper, "[YT] [SCRAPER]")
+}
 
-				bytes, err := json.Marshal(vod)
-				if err != nil {
-					log.Fatalf("[YT] [SCRAPER] Couldn't marshal VOD with ID %s into a JSON object: %v", vod.ID, err)
-				}
+// sendScheduledLivestream publishes a scheduled stream the API reports as
+// started, after the same checks a scraped detection goes through.
+func sendScheduledLivestream(cfg *config.Config, state *util.State, l *lua.LState, vod *dggarchivermodel.VOD) error {
+	if state.WasSent("youtube", vod.ID) {
+		log.Infof("[YT] [API] Stream with ID %s was already sent", vod.ID)
+		return nil
+	}
+	primary := state.CurrentStream("YouTube")
+	switch {
+	case primary.ID == "":
+		state.SetCurrentStream("YouTube", *vod)
+	case primary.ID != vod.ID && !cfg.Notifier.Platforms.YouTube.ArchiveSecondary:
+		log.Infof("[YT] [API] Stream with ID %s is running concurrently with %s, skipping it", vod.ID, primary.ID)
+		return nil
+	}
 
-				if err = cfg.NATS.NatsConnection.Publish(fmt.Sprintf("%s.job", cfg.NATS.Topic), bytes); err != nil {
-					log.Errorf("[YT] [SCRAPER] Wasn't able to send message with VOD with ID %s: %v", vod.ID, err)
-					return nil
-				}
+	job := &util.Job{VOD: *vod, Detection: util.DetectionAPI}
+	if decision := state.Decide(cfg, "YouTube", vod.ID); !decision.Archive {
+		state.Suppress(job, decision)
+		return nil
+	}
+	recheck := func() bool {
+		again, err := ScrapeVideo(cfg, vod.ID)
+		return err == nil && again.IsLive()
+	}
+	if !state.Confirm(cfg, "YouTube", job, "", recheck) {
+		return nil
+	}
 
-				if cfg.Notifier.Plugins.Enabled {
-					util.LuaCallSendFunction(l, vod)
-				}
-				state.SentVODs = append(state.SentVODs, fmt.Sprintf("youtube:%s", vod.ID))
-				state.Dump()
-			} else {
-				log.Infof("[YT] [SCRAPER] Stream with ID %s is being streamed on a different platform, skipping", id)
-			}
-		} else {
-			log.Infof("[YT] [SCRAPER] Stream with ID %s was already sent", id)
+	log.Infof("[YT] [API] Found a currently running stream with ID %s", vod.ID)
+	if cfg.Notifier.Plugins.Enabled {
+		util.LuaCallReceiveFunction(l, vod.ID)
+	}
+	return sendVOD(cfg, state, l, vod, nil, util.DetectionAPI, "[YT] [API]")
+}
+
+func videoToVOD(cfg *config.Config, vid *youtube.Video) *dggarchivermodel.VOD {
+	vod := &dggarchivermodel.VOD{
+		Platform:   "youtube",
+		Downloader: cfg.Notifier.Platforms.YouTube.Downloader,
+		ID:         vid.Id,
+	}
+	if vid.Snippet != nil {
+		vod.PubTime = vid.Snippet.PublishedAt
+		vod.Title = vid.Snippet.Title
+		if vid.Snippet.Thumbnails != nil && vid.Snippet.Thumbnails.Medium != nil {
+			vod.Thumbnail = vid.Snippet.Thumbnails.Medium.Url
 		}
-	} else {
-		state.CurrentStreams.YouTube = dggarchivermodel.VOD{}
-		log.Infof("[YT] [SCRAPER] No stream found")
 	}
-	return nil
+	if vid.LiveStreamingDetails != nil {
+		vod.StartTime = vid.LiveStreamingDetails.ActualStartTime
+		vod.EndTime = vid.LiveStreamingDetails.ActualEndTime
+	}
+	return vod
+}
+
+// sendVOD publishes vod with the manifest and attributes of the player
+// response. Streams found through the API don't come with one, so the watch
+// page is scraped for it if manifests or downloader rules need it.
+func sendVOD(cfg *config.Config, state *util.State, l *lua.LState, vod *dggarchivermodel.VOD, player *PlayerResponse, detection string, prefix string) error {
+	if player == nil && (cfg.Notifier.Manifests.Enabled || len(cfg.Notifier.Downloaders.Rules) > 0) {
+		var err error
+		if player, err = ScrapeVideo(cfg, vod.ID); err != nil {
+			log.Errorf("%s Couldn't scrape the player of stream with ID %s: %v", prefix, vod.ID, err)
+		}
+	}
+	job := &util.Job{VOD: *vod, Detection: detection}
+	if player != nil {
+		job.Metadata = player.Metadata()
+		job.Manifest = player.Manifest()
+	}
+	return state.Publish(cfg, l, job, prefix)
 }
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+)
+
+type pendingConfirmation struct {
+	id    string
+	polls int
+	// when the recheck of the stream is due, zero if none is scheduled
+	recheckAt time.Time
+}
+
+// pending returns the confirmation of stream id of platform, starting a new
+// one if platform's was for another stream. state.mu has to be held.
+func (state *State) pending(platform string, id string) *pendingConfirmation {
+	if state.confirmations == nil {
+		state.confirmations = make(map[string]*pendingConfirmation)
+	}
+	pending, ok := state.confirmations[platform]
+	if !ok || pending.id != id {
+		pending = &pendingConfirmation{id: id}
+		state.confirmations[platform] = pending
+	}
+	return pending
+}
+
+// countPoll counts a positive poll for stream id of platform and returns
+// how many there were in a row.
+func (state *State) countPoll(platform string, id string) int {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	pending := state.pending(platform, id)
+	pending.polls++
+	return pending.polls
+}
+
+// recheckDue schedules the recheck of stream id of platform delay from now
+// if there isn't one yet, and reports whether it's due. A due recheck is
+// taken off the schedule, so a rejected stream gets another one.
+func (state *State) recheckDue(platform string, id string, delay time.Duration) (bool, time.Duration) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	pending := state.pending(platform, id)
+	if pending.recheckAt.IsZero() {
+		pending.recheckAt = time.Now().Add(delay)
+		return false, delay
+	}
+	if until := time.Until(pending.recheckAt); until > 0 {
+		return false, until
+	}
+	pending.recheckAt = time.Time{}
+	return true, 0
+}
+
+// RecheckIn returns how long until the scheduled recheck of platform, the
+// field name of the platform in the config, is due, or false if there's
+// none. The loops poll again by then instead of waiting the whole sleeptime.
+func (state *State) RecheckIn(platform string) (time.Duration, bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	pending, ok := state.confirmations[platform]
+	if !ok || pending.recheckAt.IsZero() {
+		return 0, false
+	}
+	if until := time.Until(pending.recheckAt); until > 0 {
+		return until, true
+	}
+	return 0, true
+}
+
+// Confirm runs the confirmation step of platform, the field name of the
+// platform in the config, for a scraped detection of job or a scheduled
+// stream the API reports as started. It returns false if job shouldn't be
+// published yet:
+//   - polls waits for the stream to be found by a number of polls in a row
+//   - recheck schedules a recheck a few seconds later and returns false, the
+//     poll after it's due calls recheck, which has to find the stream again
+//   - manifest probes the HLS playlist at manifest, streams without one get
+//     rechecked instead
+func (state *State) Confirm(cfg *config.Config, platform string, job *Job, manifest string, recheck func() bool) bool {
+	confirmation := reflect.ValueOf(cfg.Notifier.Platforms).FieldByName(platform).FieldByName("Confirmation").Interface().(config.Confirmation)
+	mode := confirmation.Mode
+	if mode == config.ConfirmManifest && manifest == "" {
+		log.Debugf("[CONFIRM] %s stream with ID %s has no manifest to probe, rechecking it instead", platform, job.ID)
+		mode = config.ConfirmRecheck
+	}
+
+	var confirmed bool
+	var reason string
+	switch mode {
+	case "":
+		return true
+	case config.ConfirmPolls:
+		polls := state.countPoll(platform, job.ID)
+		if polls < confirmation.Polls {
+			log.Infof("[CONFIRM] %s stream with ID %s was found by %d of %d polls in a row, waiting for the next one", platform, job.ID, polls, confirmation.Polls)
+			AddMetric("confirmations", fmt.Sprintf("%s pending", job.Platform), 1)
+			return false
+		}
+		confirmed, reason = true, fmt.Sprintf("found by %d polls in a row", polls)
+	case config.ConfirmRecheck:
+		due, until := state.recheckDue(platform, job.ID, time.Duration(confirmation.Delay)*time.Second)
+		if !due {
+			log.Infof("[CONFIRM] %s stream with ID %s gets rechecked in %.f seconds", platform, job.ID, until.Seconds())
+			AddMetric("confirmations", fmt.Sprintf("%s pending", job.Platform), 1)
+			return false
+		}
+		confirmed = recheck()
+		reason = fmt.Sprintf("recheck after %d seconds", confirmation.Delay)
+	case config.ConfirmManifest:
+		if err := ProbeManifest(cfg, manifest); err != nil {
+			reason = fmt.Sprintf("manifest probe failed: %v", err)
+		} else {
+			confirmed, reason = true, "manifest is live"
+		}
+	}
+
+	if confirmed {
+		log.Infof("[CONFIRM] %s stream with ID %s is confirmed (%s)", platform, job.ID, reason)
+		AddMetric("confirmations", fmt.Sprintf("%s confirmed", job.Platform), 1)
+	} else {
+		log.Infof("[CONFIRM] %s stream with ID %s wasn't confirmed, not publishing it (%s)", platform, job.ID, reason)
+		AddMetric("confirmations", fmt.Sprintf("%s rejected", job.Platform), 1)
+	}
+	return confirmed
+}
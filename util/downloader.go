@@ -0,0 +1,66 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"golang.org/x/exp/slices"
+)
+
+// SelectDownloaders returns the downloaders job gets published to and the
+// name of the rule that picked them. Streams no rule matches go to the
+// downloader of their platform.
+func SelectDownloaders(cfg *config.Config, job *Job) ([]string, string) {
+	now := time.Now()
+	for _, rule := range cfg.Notifier.Downloaders.Rules {
+		if matchesRule(cfg, rule, job, now) {
+			return rule.Downloaders, rule.Name
+		}
+	}
+
+	platform := reflect.ValueOf(cfg.Notifier.Platforms).FieldByName(platformName(cfg, job.Platform))
+	downloader := job.Downloader
+	if platform.IsValid() {
+		downloader = platform.FieldByName("Downloader").String()
+	}
+	return []string{downloader}, "platform default"
+}
+
+func matchesRule(cfg *config.Config, rule config.DownloaderRule, job *Job, now time.Time) bool {
+	if rule.Platform != "" && !strings.EqualFold(rule.Platform, job.Platform) {
+		return false
+	}
+	if rule.Channel != "" {
+		platform := reflect.ValueOf(cfg.Notifier.Platforms).FieldByName(platformName(cfg, job.Platform))
+		if !platform.IsValid() || !strings.EqualFold(rule.Channel, platform.FieldByName("Channel").String()) {
+			return false
+		}
+	}
+	for key, value := range rule.Attributes {
+		if !hasAttribute(job.Metadata[key], value) {
+			return false
+		}
+	}
+	if rule.Window != nil && !inWindow(*rule.Window, now) {
+		return false
+	}
+	return true
+}
+
+// hasAttribute reports whether a metadata value is value, or contains it if
+// it's a list like the tags of a Kick stream.
+func hasAttribute(attribute interface{}, value string) bool {
+	switch attribute := attribute.(type) {
+	case nil:
+		return false
+	case []string:
+		return slices.ContainsFunc(attribute, func(item string) bool {
+			return strings.EqualFold(item, value)
+		})
+	default:
+		return strings.EqualFold(fmt.Sprint(attribute), value)
+	}
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DggHQ/dggarchiver-notifier/network"
+)
+
+// Classes of the errors returned by the platform loops, the scheduler picks
+// its backoff, alerting and metrics from them.
+const (
+	// network hiccups and server errors, worth retrying soon
+	ErrorTransient = "transient"
+	// the platform asked us to slow down
+	ErrorRateLimited = "rate_limited"
+	// blocked or challenged by the platform or its CDN
+	ErrorBlocked = "blocked"
+	// the page or API response didn't look like expected, usually a markup change
+	ErrorParse = "parse"
+	// bad credentials or exhausted quota
+	ErrorAuth = "auth"
+	// wrong configuration, retrying won't help
+	ErrorFatal = "fatal"
+)
+
+// ClassifiedError attaches a class to an error, errors without one are
+// treated as transient.
+type ClassifiedError struct {
+	Class      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (err *ClassifiedError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ClassifiedError) Unwrap() error {
+	return err.Err
+}
+
+func WithClass(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ClassifiedError{
+		Class: class,
+		Err:   err,
+	}
+}
+
+// WithStatus classifies err by the status code of the response it came from,
+// keeping the Retry-After of rate limited responses.
+func WithStatus(err error, statusCode int, header http.Header) error {
+	if err == nil {
+		return nil
+	}
+	classified := &ClassifiedError{
+		Class: StatusClass(statusCode),
+		Err:   err,
+	}
+	if classified.Class == ErrorRateLimited && header != nil {
+		if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+			classified.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return classified
+}
+
+func StatusClass(statusCode int) string {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorRateLimited
+	case statusCode == http.StatusUnauthorized:
+		return ErrorAuth
+	case statusCode == http.StatusForbidden:
+		return ErrorBlocked
+	case statusCode >= http.StatusBadRequest && statusCode < http.StatusInternalServerError:
+		// a page that used to be there and a request that used to work
+		return ErrorParse
+	default:
+		return ErrorTransient
+	}
+}
+
+// ErrorClass returns the class of err, the outermost one if it has several.
+// An open circuit breaker counts as being rate limited.
+func ErrorClass(err error) string {
+	var classified *ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	var breakerErr *network.BreakerOpenError
+	if errors.As(err, &breakerErr) {
+		return ErrorRateLimited
+	}
+	return ErrorTransient
+}
+
+// RetryAfter returns how long the platform asked to wait, if it did, or
+// until an open circuit breaker lets a probe through.
+func RetryAfter(err error) time.Duration {
+	var classified *ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.RetryAfter
+	}
+	var breakerErr *network.BreakerOpenError
+	if errors.As(err, &breakerErr) {
+		return breakerErr.RetryAfter
+	}
+	return 0
+}
+
+// notFoundPollsFatal is how many polls in a row a channel has to be missing
+// in before its loop gives up, a single one can be a CDN hiccup or a rename
+// in progress.
+const notFoundPollsFatal = 10
+
+// NotFound counts the polls in a row a channel was missing in.
+type NotFound struct {
+	polls int
+}
+
+// Missing records a poll the channel was missing in. err is classed as a
+// parse error, which alerts and keeps polling, until the channel has been
+// missing for long enough to be fatal.
+func (notFound *NotFound) Missing(err error) error {
+	notFound.polls++
+	if notFound.polls >= notFoundPollsFatal {
+		return WithClass(ErrorFatal, err)
+	}
+	return WithClass(ErrorParse, err)
+}
+
+// Found resets the count once the channel is there again.
+func (notFound *NotFound) Found() {
+	notFound.polls = 0
+}
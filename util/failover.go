@@ -0,0 +1,140 @@
+package util
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Candidate is a live stream the restream policy suppressed in favour of a
+// higher priority one, it gets promoted once that one ends or stalls.
+type Candidate struct {
+	Job          *Job
+	SuppressedBy string
+	Since        time.Time
+}
+
+// platformName returns the field name of platform in the config, e.g.
+// YouTube for youtube.
+func platformName(cfg *config.Config, platform string) string {
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(cfg.Notifier.Platforms)) {
+		if strings.EqualFold(field.Name, platform) {
+			return field.Name
+		}
+	}
+	return platform
+}
+
+// Suppress keeps job as a failover candidate if the policy skipped it
+// because of a higher priority stream, the other decisions aren't revisited.
+func (state *State) Suppress(job *Job, decision Decision) {
+	if decision.Archive || decision.Rule != RulePriority {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.suppressed == nil {
+		state.suppressed = make(map[string]*Candidate)
+	}
+	candidate, ok := state.suppressed[job.Platform]
+	if !ok || candidate.Job.ID != job.ID {
+		candidate = &Candidate{Since: time.Now()}
+		state.suppressed[job.Platform] = candidate
+		SetMetric("policy_suppressed", job.Platform, 1)
+	}
+	candidate.Job = job
+	candidate.SuppressedBy = decision.SuppressedBy
+}
+
+// candidates returns the suppressed streams that are still live, highest
+// priority first, forgetting the rest.
+func (state *State) candidates(cfg *config.Config) []*Candidate {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	candidates := make([]*Candidate, 0, len(state.suppressed))
+	for platform, candidate := range state.suppressed {
+		current := reflect.ValueOf(state.CurrentStreams).FieldByName(platformName(cfg, platform)).FieldByName("ID").String()
+		if current != candidate.Job.ID || state.wasSent(platform, candidate.Job.ID) {
+			delete(state.suppressed, platform)
+			SetMetric("policy_suppressed", platform, 0)
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	platforms := reflect.ValueOf(cfg.Notifier.Platforms)
+	sort.Slice(candidates, func(i, j int) bool {
+		return platforms.FieldByName(platformName(cfg, candidates[i].Job.Platform)).FieldByName("Priority").Int() <
+			platforms.FieldByName(platformName(cfg, candidates[j].Job.Platform)).FieldByName("Priority").Int()
+	})
+	return candidates
+}
+
+// takeCandidate removes and returns the candidate of platform if it's still
+// the stream with the given ID, so a promotion happens only once.
+func (state *State) takeCandidate(platform string, id string) *Candidate {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	candidate, ok := state.suppressed[platform]
+	if !ok || candidate.Job.ID != id {
+		return nil
+	}
+	delete(state.suppressed, platform)
+	SetMetric("policy_suppressed", platform, 0)
+	return candidate
+}
+
+// link adds the stream the candidate is replacing to the metadata of job.
+func (candidate *Candidate) link(job *Job) {
+	AddMetric("policy_failovers", job.Platform, 1)
+	if job.Metadata == nil {
+		job.Metadata = make(map[string]interface{})
+	}
+	job.Metadata["failover"] = map[string]interface{}{
+		"replaces":         candidate.SuppressedBy,
+		"suppressed_since": candidate.Since.UTC().Format(time.RFC3339),
+	}
+}
+
+// linkSuppressed links job to the stream it's replacing if it was a
+// suppressed candidate, in case its own platform's check publishes it before
+// the failover does.
+func (state *State) linkSuppressed(job *Job) {
+	if candidate := state.takeCandidate(job.Platform, job.ID); candidate != nil {
+		candidate.link(job)
+	}
+}
+
+// Failover runs the policy again for the suppressed streams and publishes
+// the ones it lets through now, which happens as soon as the suppressing
+// stream ends or hasn't been seen for the priority fallback time. Promoted
+// jobs link to the stream they're replacing in their metadata.
+func (state *State) Failover(cfg *config.Config, l *lua.LState) error {
+	for _, candidate := range state.candidates(cfg) {
+		job := candidate.Job
+		platform := platformName(cfg, job.Platform)
+		decision := state.decide(cfg, platform, time.Now())
+		if !decision.Archive || state.takeCandidate(job.Platform, job.ID) == nil {
+			continue
+		}
+		candidate.link(job)
+
+		log.Infof("[POLICY] Promoting %s stream with ID %s, it was suppressed by %s for %.f minutes: %s", platform, job.ID, candidate.SuppressedBy, time.Since(candidate.Since).Minutes(), decision.Reason)
+		if cfg.Notifier.Plugins.Enabled {
+			LuaCallReceiveFunction(l, job.ID)
+		}
+		if err := state.Publish(cfg, l, job, "[POLICY]"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
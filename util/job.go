@@ -0,0 +1,145 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Job is the message published to the <topic>.job NATS topic, the VOD's
+// fields stay at the top level so older workers can still read it.
+type Job struct {
+	dggarchivermodel.VOD
+	// unique to every job, a stream published to several downloaders gets
+	// a job per downloader that share the VOD's ID
+	JobID string `json:"jobid,omitempty"`
+	// how the stream was found, one of the Detection constants
+	Detection   string `json:"detection,omitempty"`
+	PublishedAt string `json:"publishedat,omitempty"`
+	// notifier instance that published the job and the W3C trace the jobs
+	// of the stream belong to, also sent as NATS headers
+	Instance string `json:"instance,omitempty"`
+	TraceID  string `json:"traceid,omitempty"`
+	// platform specific details, e.g. the category and tags of a Kick stream
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// broadcast session the stream belongs to and the platform:id of the
+	// other streams in it
+	SessionID string   `json:"sessionid,omitempty"`
+	Siblings  []string `json:"siblings,omitempty"`
+	// ID of the crashed stream this one continues and its part number
+	Continues string `json:"continues,omitempty"`
+	Part      int    `json:"part,omitempty"`
+	// media manifest of the stream, only set when manifests get resolved
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+// Detection methods of a job.
+const (
+	DetectionAPI     = "api"
+	DetectionScraper = "scraper"
+	DetectionWebSub  = "websub"
+)
+
+// resolveJobManifest resolves the manifest the platform found for job, it
+// returns false if the job shouldn't be published because the manifest is
+// dead. Jobs keep their manifest only while manifests are enabled.
+func resolveJobManifest(cfg *config.Config, job *Job, prefix string) bool {
+	if !cfg.Notifier.Manifests.Enabled || job.Manifest == nil {
+		job.Manifest = nil
+		return true
+	}
+
+	err := ResolveManifest(cfg, job.Manifest)
+	switch {
+	case err == nil:
+		log.Infof("%s Resolved the %s manifest of stream with ID %s, %d variant(s)", prefix, job.Manifest.Type, job.ID, len(job.Manifest.Variants))
+		AddMetric("manifests", fmt.Sprintf("%s live", job.Platform), 1)
+	case cfg.Notifier.Manifests.SkipDead:
+		log.Infof("%s Manifest of stream with ID %s isn't live, not publishing it yet: %v", prefix, job.ID, err)
+		AddMetric("manifests", fmt.Sprintf("%s dead", job.Platform), 1)
+		return false
+	default:
+		log.Errorf("%s Couldn't resolve the manifest of stream with ID %s, publishing it without one: %v", prefix, job.ID, err)
+		AddMetric("manifests", fmt.Sprintf("%s dead", job.Platform), 1)
+		job.Manifest = nil
+	}
+	return true
+}
+
+// Publish sends job to the <topic>.job NATS topic once for every downloader
+// the downloader rules pick, records the stream as sent and runs the send
+// function of the Lua plugin once all of them are out. Jobs with a dead manifest are held
+// back if manifests are enabled with skip_dead. A stream is only ever
+// published once, whichever thread gets to it first.
+func (state *State) Publish(cfg *config.Config, l *lua.LState, job *Job, prefix string) error {
+	// the failover and the thread of the platform can both get here
+	if !state.claimSend(job.Platform, job.ID) {
+		log.Infof("%s Stream with ID %s was already sent", prefix, job.ID)
+		return nil
+	}
+	defer state.releaseSend(job.Platform, job.ID)
+
+	if !resolveJobManifest(cfg, job, prefix) {
+		return nil
+	}
+	state.linkSuppressed(job)
+	state.stitchRestart(cfg, job)
+	state.Correlate(cfg, job.VOD)
+	if job.Continues != "" {
+		state.continueSession(job)
+	}
+	job.SessionID, job.Siblings = state.SessionOf(job.Platform, job.ID)
+
+	// every job of the stream is a span of the same trace
+	job.TraceID = NewTraceID()
+	job.Instance = cfg.Notifier.Instance
+	downloaders, rule := SelectDownloaders(cfg, job)
+	if len(downloaders) > 1 {
+		log.Infof("%s Sending stream with ID %s to %s (%s, trace %s)", prefix, job.ID, strings.Join(downloaders, ", "), rule, job.TraceID)
+	}
+	for _, downloader := range downloaders {
+		// a previous attempt that failed halfway already sent this one
+		if jobID := state.sentJob(job.Platform, job.ID, downloader); jobID != "" {
+			log.Infof("%s Job %s of stream with ID %s was already sent to %s", prefix, jobID, job.ID, downloader)
+			continue
+		}
+		downloaderJob := *job
+		downloaderJob.Downloader = downloader
+		downloaderJob.JobID = uuid.NewString()
+		downloaderJob.PublishedAt = time.Now().UTC().Format(time.RFC3339)
+
+		bytes, err := json.Marshal(&downloaderJob)
+		if err != nil {
+			log.Fatalf("%s Couldn't marshal VOD with ID %s into a JSON object: %v", prefix, job.ID, err)
+		}
+
+		header := nats.Header{}
+		header.Set(HeaderTraceparent, Traceparent(job.TraceID))
+		header.Set(HeaderJobID, downloaderJob.JobID)
+		header.Set(HeaderMsgID, jobKey(job.Platform, job.ID, downloader))
+		header.Set(HeaderDetection, job.Detection)
+		if err = publish(cfg, "job", bytes, header); err != nil {
+			state.Dump()
+			return fmt.Errorf("%s Wasn't able to send job %s with VOD with ID %s: %w", prefix, downloaderJob.JobID, job.ID, err)
+		}
+		state.markJobSent(job.Platform, job.ID, downloader, downloaderJob.JobID)
+		log.Infof("%s Published job %s of stream with ID %s to %s (%s, trace %s)", prefix, downloaderJob.JobID, job.ID, downloader, job.Detection, job.TraceID)
+		AddMetric("downloader_jobs", fmt.Sprintf("%s %s", job.Platform, downloader), 1)
+	}
+	state.MarkSent(job.Platform, job.ID, downloaders...)
+	// the plugin hears about the stream once, not about each of its jobs
+	if cfg.Notifier.Plugins.Enabled {
+		LuaCallSendFunction(l, &job.VOD)
+	}
+	state.RemoveScheduled(job.Platform, job.ID)
+	state.Dump()
+	return nil
+}
@@ -0,0 +1,286 @@
+package util
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+)
+
+// biggest playlist that gets downloaded
+const maxPlaylistSize = 1 << 20
+
+var (
+	ErrNotAPlaylist   = errors.New("not an HLS playlist")
+	ErrPlaylistEnded  = errors.New("playlist has ended")
+	ErrEmptyPlaylist  = errors.New("playlist has no segments")
+	ErrPlaylistStatus = errors.New("unexpected status code")
+	ErrNotLive        = errors.New("DASH manifest isn't dynamic")
+)
+
+// Manifest types.
+const (
+	ManifestHLS  = "hls"
+	ManifestDASH = "dash"
+)
+
+// Manifest is the media manifest of a stream, resolved so workers don't
+// have to do it themselves.
+type Manifest struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+	// RFC3339 time the URL stops working at, if it's known
+	Expires  string    `json:"expires,omitempty"`
+	Variants []Variant `json:"variants,omitempty"`
+}
+
+// Variant is one of the renditions of a manifest.
+type Variant struct {
+	URL        string  `json:"url,omitempty"`
+	Bandwidth  int     `json:"bandwidth"`
+	Resolution string  `json:"resolution,omitempty"`
+	FrameRate  float64 `json:"framerate,omitempty"`
+	Codecs     string  `json:"codecs,omitempty"`
+}
+
+// fetchPlaylist returns the lines of the HLS playlist at playlistURL.
+func fetchPlaylist(cfg *config.Config, playlistURL string) ([]string, error) {
+	client := network.NewClient("manifests", nil, cfg.Notifier.Network.Options())
+	resp, err := client.Get(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, WithStatus(fmt.Errorf("%w: %d", ErrPlaylistStatus, resp.StatusCode), resp.StatusCode, resp.Header)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxPlaylistSize))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[0] != "#EXTM3U" {
+		return nil, ErrNotAPlaylist
+	}
+	return lines, nil
+}
+
+// resolveURI resolves a URI of a playlist against the playlist's URL.
+func resolveURI(base string, uri string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(ref).String(), nil
+}
+
+// ProbeManifest checks that the HLS playlist at manifestURL is a running
+// livestream: a media playlist with segments and without an end tag. The
+// first variant of a master playlist is checked in its place.
+func ProbeManifest(cfg *config.Config, manifestURL string) error {
+	return resolveHLS(cfg, &Manifest{URL: manifestURL, Type: ManifestHLS})
+}
+
+func probeMediaPlaylist(lines []string) error {
+	segments := 0
+	for _, line := range lines {
+		switch {
+		case line == "#EXT-X-ENDLIST":
+			return ErrPlaylistEnded
+		case strings.HasPrefix(line, "#EXTINF"):
+			segments++
+		}
+	}
+	if segments == 0 {
+		return ErrEmptyPlaylist
+	}
+	return nil
+}
+
+// parseAttributes parses the attribute list of an HLS tag, e.g.
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2".
+func parseAttributes(list string) map[string]string {
+	attributes := make(map[string]string)
+	for list != "" {
+		key, rest, found := strings.Cut(list, "=")
+		if !found {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			value, rest, _ = strings.Cut(rest[1:], "\"")
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attributes[strings.TrimSpace(key)] = value
+		list = rest
+	}
+	return attributes
+}
+
+// expiryFromURL returns the expiry of a signed URL that carries it as an
+// expire query parameter or path segment, as the YouTube ones do.
+func expiryFromURL(manifestURL string) time.Time {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return time.Time{}
+	}
+	expire := u.Query().Get("expire")
+	if expire == "" {
+		segments := strings.Split(u.Path, "/")
+		for i := 0; i+1 < len(segments); i++ {
+			if segments[i] == "expire" {
+				expire = segments[i+1]
+				break
+			}
+		}
+	}
+	seconds, err := strconv.ParseInt(expire, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// ResolveManifest parses the variants of manifest and checks that it's
+// still live, a dead manifest is returned along with the error.
+func ResolveManifest(cfg *config.Config, manifest *Manifest) error {
+	if manifest.Expires == "" {
+		if expires := expiryFromURL(manifest.URL); !expires.IsZero() {
+			manifest.Expires = expires.UTC().Format(time.RFC3339)
+		}
+	}
+	if manifest.Type == ManifestDASH {
+		return resolveDASH(cfg, manifest)
+	}
+	return resolveHLS(cfg, manifest)
+}
+
+func resolveHLS(cfg *config.Config, manifest *Manifest) error {
+	lines, err := fetchPlaylist(cfg, manifest.URL)
+	if err != nil {
+		return err
+	}
+
+	manifest.Variants = nil
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "#EXT-X-STREAM-INF:") || i+1 >= len(lines) || strings.HasPrefix(lines[i+1], "#") {
+			continue
+		}
+		variantURL, err := resolveURI(manifest.URL, lines[i+1])
+		if err != nil {
+			return err
+		}
+		attributes := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+		variant := Variant{
+			URL:        variantURL,
+			Resolution: attributes["RESOLUTION"],
+			Codecs:     attributes["CODECS"],
+		}
+		variant.Bandwidth, _ = strconv.Atoi(attributes["BANDWIDTH"])
+		variant.FrameRate, _ = strconv.ParseFloat(attributes["FRAME-RATE"], 64)
+		manifest.Variants = append(manifest.Variants, variant)
+	}
+
+	if len(manifest.Variants) == 0 {
+		return probeMediaPlaylist(lines)
+	}
+	variantLines, err := fetchPlaylist(cfg, manifest.Variants[0].URL)
+	if err != nil {
+		return err
+	}
+	return probeMediaPlaylist(variantLines)
+}
+
+type mpd struct {
+	Type    string `xml:"type,attr"`
+	Periods []struct {
+		AdaptationSets []struct {
+			MimeType        string `xml:"mimeType,attr"`
+			Representations []struct {
+				Bandwidth int    `xml:"bandwidth,attr"`
+				Width     int    `xml:"width,attr"`
+				Height    int    `xml:"height,attr"`
+				FrameRate string `xml:"frameRate,attr"`
+				Codecs    string `xml:"codecs,attr"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+// parseFrameRate parses a DASH frame rate, either a number or a fraction
+// like 30000/1001.
+func parseFrameRate(frameRate string) float64 {
+	numerator, denominator, found := strings.Cut(frameRate, "/")
+	value, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+	if found {
+		divisor, err := strconv.ParseFloat(denominator, 64)
+		if err != nil || divisor == 0 {
+			return 0
+		}
+		value /= divisor
+	}
+	return value
+}
+
+func resolveDASH(cfg *config.Config, manifest *Manifest) error {
+	client := network.NewClient("manifests", nil, cfg.Notifier.Network.Options())
+	resp, err := client.Get(manifest.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return WithStatus(fmt.Errorf("%w: %d", ErrPlaylistStatus, resp.StatusCode), resp.StatusCode, resp.Header)
+	}
+
+	var parsed mpd
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxPlaylistSize)).Decode(&parsed); err != nil {
+		return fmt.Errorf("%w: %s", ErrNotAPlaylist, err)
+	}
+
+	manifest.Variants = nil
+	for _, period := range parsed.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, representation := range set.Representations {
+				variant := Variant{
+					Bandwidth: representation.Bandwidth,
+					FrameRate: parseFrameRate(representation.FrameRate),
+					Codecs:    representation.Codecs,
+				}
+				if representation.Width > 0 && representation.Height > 0 {
+					variant.Resolution = fmt.Sprintf("%dx%d", representation.Width, representation.Height)
+				}
+				manifest.Variants = append(manifest.Variants, variant)
+			}
+		}
+	}
+
+	if parsed.Type != "dynamic" {
+		return ErrNotLive
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package util
+
+import (
+	"github.com/DggHQ/dggarchiver-notifier/network"
+)
+
+// metrics are served by the HTTP server on /debug/vars
+var metrics = network.NewMetrics("notifier")
+
+// SetMetric sets the value of the metric name in group.
+func SetMetric(group string, name string, value int64) {
+	metrics.Set(group, name, value)
+}
+
+// AddMetric adds delta to the metric name in group.
+func AddMetric(group string, name string, delta int64) {
+	metrics.Add(group, name, delta)
+}
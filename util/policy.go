@@ -0,0 +1,153 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"golang.org/x/exp/slices"
+)
+
+// Rules of the restream policy, in the order they're evaluated.
+const (
+	RuleOverride = "override"
+	RuleEvent    = "event"
+	RuleWindow   = "window"
+	RulePriority = "priority"
+)
+
+// Decision is the outcome of the restream policy for a detected stream.
+type Decision struct {
+	Archive bool
+	// the rule that decided and why
+	Rule   string
+	Reason string
+	// platform:id of the higher priority stream that suppressed this one
+	SuppressedBy string
+}
+
+func (decision Decision) action() string {
+	if decision.Archive {
+		return config.PolicyArchive
+	}
+	return config.PolicySkip
+}
+
+// Decide runs the restream policy for stream id of platform, the field name
+// of the platform in the config. The first rule that applies decides:
+//  1. a manual override of the platform or of every platform
+//  2. an archive all event that's going on
+//  3. the time windows of the platform, if it has any
+//  4. the restream priority, a live stream of a higher priority platform
+//     suppresses the others unless it hasn't been seen for the fallback time
+//
+// Every decision is logged with its reason.
+func (state *State) Decide(cfg *config.Config, platform string, id string) Decision {
+	decision := state.decide(cfg, platform, time.Now())
+	log.Infof("[POLICY] %s stream with ID %s: %s by the %s rule, %s", platform, id, decision.action(), decision.Rule, decision.Reason)
+	AddMetric("policy_decisions", fmt.Sprintf("%s %s %s", strings.ToLower(platform), decision.Rule, decision.action()), 1)
+	return decision
+}
+
+func (state *State) decide(cfg *config.Config, platform string, now time.Time) Decision {
+	policy := cfg.Notifier.Policy
+
+	for _, override := range policy.Overrides {
+		if override.Platform != "*" && !strings.EqualFold(override.Platform, platform) {
+			continue
+		}
+		if !override.UntilTime.IsZero() && now.After(override.UntilTime) {
+			continue
+		}
+		reason := "manual override"
+		if override.Reason != "" {
+			reason = fmt.Sprintf("manual override (%s)", override.Reason)
+		}
+		return Decision{Archive: override.Action == config.PolicyArchive, Rule: RuleOverride, Reason: reason}
+	}
+
+	for _, event := range policy.ArchiveAll {
+		if !now.Before(event.StartTime) && now.Before(event.EndTime) {
+			return Decision{Archive: true, Rule: RuleEvent, Reason: fmt.Sprintf("archiving everything during %q", event.Name)}
+		}
+	}
+
+	hasWindow := false
+	for _, window := range policy.Windows {
+		if !strings.EqualFold(window.Platform, platform) {
+			continue
+		}
+		hasWindow = true
+		if inWindow(window, now) {
+			hasWindow = false
+			break
+		}
+	}
+	if hasWindow {
+		return Decision{Archive: false, Rule: RuleWindow, Reason: "outside of the platform's archive windows"}
+	}
+
+	return state.decidePriority(cfg, platform, now)
+}
+
+// inWindow reports whether now falls into window. The day of a window that
+// wraps around midnight is the one it started on.
+func inWindow(window config.PolicyWindow, now time.Time) bool {
+	t := now.In(window.Location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	switch {
+	case window.FromOffset < window.ToOffset:
+		if offset < window.FromOffset || offset >= window.ToOffset {
+			return false
+		}
+	case offset >= window.FromOffset:
+	case offset < window.ToOffset:
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	return len(window.Weekdays) == 0 || slices.Contains(window.Weekdays, day)
+}
+
+func (state *State) decidePriority(cfg *config.Config, platform string, now time.Time) Decision {
+	platformsValue := reflect.ValueOf(cfg.Notifier.Platforms)
+	priority := platformsValue.FieldByName(platform).FieldByName("Priority").Int()
+	if priority <= 0 {
+		return Decision{Archive: true, Rule: RulePriority, Reason: "no restream priority is set"}
+	}
+	fallback := time.Duration(cfg.Notifier.Policy.PriorityFallback) * time.Minute
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	reason := "no higher priority platform is live"
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(cfg.Notifier.Platforms)) {
+		other := platformsValue.FieldByName(field.Name)
+		if field.Name == platform || !other.FieldByName("Enabled").Bool() {
+			continue
+		}
+		otherPriority := other.FieldByName("Priority").Int()
+		if otherPriority <= 0 || otherPriority >= priority {
+			continue
+		}
+		id := reflect.ValueOf(state.CurrentStreams).FieldByName(field.Name).FieldByName("ID").String()
+		if id == "" {
+			continue
+		}
+		if seen := state.lastSeen[field.Name]; fallback > 0 && now.Sub(seen) > fallback {
+			reason = fmt.Sprintf("%s stream with ID %s hasn't been seen for %.f minutes, falling back", field.Name, id, now.Sub(seen).Minutes())
+			continue
+		}
+		return Decision{
+			Archive:      false,
+			Rule:         RulePriority,
+			Reason:       fmt.Sprintf("%s has a higher priority and is live with stream ID %s", field.Name, id),
+			SuppressedBy: fmt.Sprintf("%s:%s", strings.ToLower(field.Name), id),
+		}
+	}
+	return Decision{Archive: true, Rule: RulePriority, Reason: reason}
+}
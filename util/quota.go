@@ -0,0 +1,152 @@
+package util
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+)
+
+// YouTube Data API costs, see https://developers.google.com/youtube/v3/determine_quota_cost
+const (
+	QuotaSearchList   = 100
+	QuotaVideosList   = 1
+	QuotaChannelsList = 1
+)
+
+// the daily YouTube quota resets at midnight Pacific time
+var quotaLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	return loc
+}()
+
+type KeyQuota struct {
+	Used      int
+	Exhausted bool
+}
+
+type Quota struct {
+	mu    sync.Mutex
+	Day   string
+	Used  int
+	Calls map[string]int
+	Keys  map[string]*KeyQuota
+}
+
+// MarshalJSON locks the quota so it can be dumped along with the state
+// while the platform loops are using it.
+func (q *Quota) MarshalJSON() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	type quota Quota
+	return json.Marshal((*quota)(q))
+}
+
+func quotaDay(t time.Time) string {
+	return t.In(quotaLocation).Format("2006-01-02")
+}
+
+func quotaReset(t time.Time) time.Time {
+	t = t.In(quotaLocation)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, quotaLocation)
+}
+
+func (q *Quota) resetIfNewDay() {
+	if day := quotaDay(time.Now()); q.Day != day {
+		if q.Day != "" {
+			log.Infof("[YT] Quota day changed, resetting the used quota (%d units used on %s)", q.Used, q.Day)
+		}
+		q.Day = day
+		q.Used = 0
+		q.Calls = make(map[string]int)
+		q.Keys = make(map[string]*KeyQuota)
+		q.updateMetrics()
+	}
+}
+
+func (q *Quota) key(name string) *KeyQuota {
+	if _, ok := q.Keys[name]; !ok {
+		q.Keys[name] = &KeyQuota{}
+	}
+	return q.Keys[name]
+}
+
+func (q *Quota) updateMetrics() {
+	SetMetric("youtube_quota", "used", int64(q.Used))
+	for method, calls := range q.Calls {
+		SetMetric("youtube_quota_calls", method, int64(calls))
+	}
+	for name, key := range q.Keys {
+		SetMetric("youtube_quota_keys_used", name, int64(key.Used))
+		if key.Exhausted {
+			SetMetric("youtube_quota_keys_exhausted", name, 1)
+		} else {
+			SetMetric("youtube_quota_keys_exhausted", name, 0)
+		}
+	}
+}
+
+// Spend records an API call of method costing cost units, made with the
+// credentials called key.
+func (q *Quota) Spend(key string, method string, cost int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetIfNewDay()
+	q.Used += cost
+	q.Calls[method]++
+	q.key(key).Used += cost
+	q.updateMetrics()
+}
+
+// Exhaust marks the credentials called key as out of quota until the reset.
+func (q *Quota) Exhaust(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetIfNewDay()
+	q.key(key).Exhausted = true
+	q.updateMetrics()
+}
+
+// IsExhausted reports whether the credentials called key are out of quota.
+func (q *Quota) IsExhausted(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetIfNewDay()
+	return q.key(key).Exhausted
+}
+
+// Remaining returns how many units are left of the daily budget of every
+// credentials in keys that haven't been exhausted yet.
+func (q *Quota) Remaining(budget int, keys []string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetIfNewDay()
+
+	var remaining int
+	for _, name := range keys {
+		key := q.key(name)
+		if !key.Exhausted && key.Used < budget {
+			remaining += budget - key.Used
+		}
+	}
+	SetMetric("youtube_quota", "budget", int64(budget*len(keys)))
+	SetMetric("youtube_quota", "remaining", int64(remaining))
+	return remaining
+}
+
+// Interval returns how often a check costing cost units can run so that
+// the rest of the budget lasts until the quota resets. When the budget
+// can't cover a single check, it returns the time left until the reset.
+func (q *Quota) Interval(budget int, keys []string, cost int) time.Duration {
+	remaining := q.Remaining(budget, keys)
+	untilReset := time.Until(quotaReset(time.Now()))
+	if remaining < cost {
+		return untilReset
+	}
+	return untilReset / time.Duration(remaining/cost)
+}
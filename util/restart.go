@@ -0,0 +1,106 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+)
+
+// restartOverlap is how long a continuation can seem to have started before
+// the last stream ended, the platforms keep showing an ended stream as live
+// for a bit. Streams starting earlier ran at the same time as that one.
+const restartOverlap = time.Minute
+
+// EndedStream is the last stream of a platform's channel that ended, a
+// stream starting soon after it with a similar title is its continuation.
+type EndedStream struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Part    int       `json:"part"`
+	EndTime time.Time `json:"endtime"`
+}
+
+// streamEnded records previous as the platform's ended stream, the caller
+// has to hold the lock. Streams no downloader got, because the policy
+// suppressed them or they never got confirmed, have nothing to continue.
+func (state *State) streamEnded(previous dggarchivermodel.VOD, lastSeen time.Time) {
+	key := fmt.Sprintf("%s:%s", previous.Platform, previous.ID)
+	if !state.wasSent(previous.Platform, previous.ID) {
+		delete(state.Parts, key)
+		return
+	}
+	if state.EndedStreams == nil {
+		state.EndedStreams = make(map[string]EndedStream)
+	}
+	state.EndedStreams[previous.Platform] = EndedStream{
+		ID:      previous.ID,
+		Title:   previous.Title,
+		Part:    state.Parts[key],
+		EndTime: lastSeen,
+	}
+	delete(state.Parts, key)
+}
+
+// stitchRestart marks job as the next part of the platform's last stream if
+// it started within the restart window after that one ended and has a
+// similar title. Every ended stream gets one continuation at most.
+func (state *State) stitchRestart(cfg *config.Config, job *Job) {
+	if !cfg.Notifier.Restarts.Enabled {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	previous, ok := state.EndedStreams[job.Platform]
+	if !ok || previous.ID == job.ID {
+		return
+	}
+	start, err := time.Parse(time.RFC3339, job.StartTime)
+	if err != nil {
+		start = time.Now()
+	}
+	gap := start.Sub(previous.EndTime)
+	if gap < -restartOverlap || gap > time.Duration(cfg.Notifier.Restarts.Window)*time.Minute {
+		return
+	}
+	similarity := titleSimilarity(previous.Title, job.Title)
+	if similarity < cfg.Notifier.Restarts.TitleSimilarity {
+		log.Debugf("[RESTART] %s stream with ID %s started %.f seconds after %s ended, but the titles are too different (%.2f)", job.Platform, job.ID, gap.Seconds(), previous.ID, similarity)
+		return
+	}
+
+	part := previous.Part
+	if part == 0 {
+		part = 1
+	}
+	job.Continues = previous.ID
+	job.Part = part + 1
+	if state.Parts == nil {
+		state.Parts = make(map[string]int)
+	}
+	state.Parts[fmt.Sprintf("%s:%s", job.Platform, job.ID)] = job.Part
+	delete(state.EndedStreams, job.Platform)
+
+	log.Infof("[RESTART] %s stream with ID %s started %.f seconds after %s ended, publishing it as part %d", job.Platform, job.ID, gap.Seconds(), previous.ID, job.Part)
+	AddMetric("restarts", job.Platform, 1)
+}
+
+// continueSession moves a continuation into the session of the stream it
+// continues, unless it already joined another broadcast.
+func (state *State) continueSession(job *Job) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	key := fmt.Sprintf("%s:%s", job.Platform, job.ID)
+	previous := state.sessionOf(fmt.Sprintf("%s:%s", job.Platform, job.Continues))
+	current := state.sessionOf(key)
+	if previous == nil || current == nil || previous == current || len(current.Streams) > 1 {
+		return
+	}
+	previous.Streams[key] = current.Streams[key]
+	delete(state.Sessions, current.ID)
+}
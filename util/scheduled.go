@@ -0,0 +1,128 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+)
+
+const (
+	// polling gets tightened this long before a scheduled stream
+	scheduledLead = 2 * time.Minute
+	// and stays tight for this long after its scheduled start
+	scheduledWindow = 30 * time.Minute
+	scheduledPoll   = 30 * time.Second
+)
+
+// scheduledPlatforms are the platforms scheduled streams get tracked for,
+// neither Kick nor Rumble expose their upcoming streams.
+var scheduledPlatforms = []string{"youtube"}
+
+// ScheduledStream is an upcoming stream, published to the
+// <topic>.stream.scheduled NATS topic. It has no session ID, sessions are
+// only assigned once a stream goes live.
+type ScheduledStream struct {
+	Platform           string `json:"platform"`
+	ID                 string `json:"id"`
+	Title              string `json:"title"`
+	Thumbnail          string `json:"thumbnail"`
+	ScheduledStartTime string `json:"scheduledstarttime"`
+}
+
+func (stream *ScheduledStream) key() string {
+	return fmt.Sprintf("%s:%s", stream.Platform, stream.ID)
+}
+
+func (stream *ScheduledStream) startTime() (time.Time, error) {
+	return time.Parse(time.RFC3339, stream.ScheduledStartTime)
+}
+
+// AddScheduled records an upcoming stream and publishes it to the
+// <topic>.stream.scheduled NATS topic, unless it was already announced with
+// the same scheduled start time.
+func (state *State) AddScheduled(cfg *config.Config, stream ScheduledStream) {
+	start, err := stream.startTime()
+	if err != nil {
+		log.Errorf("Couldn't parse the scheduled start time of %s: %s", stream.key(), err)
+		return
+	}
+
+	state.mu.Lock()
+	if state.ScheduledStreams == nil {
+		state.ScheduledStreams = make(map[string]ScheduledStream)
+	}
+	existing, found := state.ScheduledStreams[stream.key()]
+	state.ScheduledStreams[stream.key()] = stream
+	state.mu.Unlock()
+
+	if found && existing.ScheduledStartTime == stream.ScheduledStartTime {
+		return
+	}
+
+	log.Infof("Found a scheduled stream %s starting at %s", stream.key(), start.Format(time.RFC3339))
+	bytes, err := json.Marshal(stream)
+	if err != nil {
+		log.Fatalf("Couldn't marshal scheduled stream %s into a JSON object: %v", stream.key(), err)
+	}
+	if err := publish(cfg, "stream.scheduled", bytes, nil); err != nil {
+		log.Errorf("Wasn't able to send message with scheduled stream %s: %v", stream.key(), err)
+	}
+	state.Dump()
+}
+
+// RemoveScheduled forgets an upcoming stream, usually once it went live.
+func (state *State) RemoveScheduled(platform string, id string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	delete(state.ScheduledStreams, fmt.Sprintf("%s:%s", platform, id))
+}
+
+// ScheduledStreamsDue returns the upcoming streams of platform that are
+// about to start or should've started recently.
+func (state *State) ScheduledStreamsDue(platform string) []ScheduledStream {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	now := time.Now()
+	var due []ScheduledStream
+	for key, stream := range state.ScheduledStreams {
+		start, err := stream.startTime()
+		if err != nil || now.After(start.Add(scheduledWindow)) {
+			delete(state.ScheduledStreams, key)
+			continue
+		}
+		if stream.Platform == platform && now.After(start.Add(-scheduledLead)) {
+			due = append(due, stream)
+		}
+	}
+	return due
+}
+
+// PollInterval shortens sleeptime of the platform's loops around the
+// start of its scheduled streams.
+func (state *State) PollInterval(platform string, sleeptime time.Duration) time.Duration {
+	if len(state.ScheduledStreamsDue(platform)) > 0 {
+		return scheduledPoll
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	interval := sleeptime
+	for _, stream := range state.ScheduledStreams {
+		start, err := stream.startTime()
+		if err != nil || stream.Platform != platform {
+			continue
+		}
+		if untilLead := time.Until(start.Add(-scheduledLead)); untilLead < interval {
+			interval = untilLead
+		}
+	}
+	if interval < scheduledPoll {
+		interval = scheduledPoll
+	}
+	return interval
+}
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	luaLibs "github.com/vadv/gopher-lua-libs"
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/exp/slices"
+)
+
+// failures in a row before transient and rate limited errors raise an alert
+const alertAfterFailures = 5
+
+// LoopFunc checks a platform once, the scheduler runs it every sleep time.
+type LoopFunc func(*config.Config, *State, *lua.LState) error
+
+type backoff struct {
+	min time.Duration
+	max time.Duration
+	// whether the first failure is worth an alert
+	alert bool
+}
+
+var backoffs = map[string]backoff{
+	ErrorTransient:   {min: time.Second, max: 64 * time.Second},
+	ErrorRateLimited: {min: time.Minute, max: 30 * time.Minute},
+	ErrorBlocked:     {min: 5 * time.Minute, max: time.Hour, alert: true},
+	ErrorAuth:        {min: 15 * time.Minute, max: time.Hour, alert: true},
+	// the sleep time is used, the markup won't change back any time soon
+	ErrorParse: {alert: true},
+}
+
+type thread struct {
+	prefix      string
+	platform    string
+	f           LoopFunc
+	cfg         *config.Config
+	state       *State
+	sleeptime   time.Duration
+	trigger     <-chan struct{}
+	healthCheck string
+
+	class    string
+	delay    time.Duration
+	failures int
+	alerted  bool
+}
+
+// StartThread runs f in its own goroutine with its own Lua state. platform
+// is the field name of the platform in the config. After an error, the
+// thread backs off by the error's class and pings the platform's healthcheck
+// URL with /fail once it's worth an alert; successful checks ping the plain
+// URL. trigger can wake up the thread before its sleep time runs out.
+func StartThread(prefix string, platform string, f LoopFunc, cfg *config.Config, state *State, sleeptime time.Duration, trigger <-chan struct{}) {
+	t := &thread{
+		prefix:      prefix,
+		platform:    platform,
+		f:           f,
+		cfg:         cfg,
+		state:       state,
+		sleeptime:   sleeptime,
+		trigger:     trigger,
+		healthCheck: reflect.ValueOf(cfg.Notifier.Platforms).FieldByName(platform).FieldByName("HealthCheck").String(),
+	}
+	go t.run()
+}
+
+func (t *thread) metricName() string {
+	return strings.TrimSpace(strings.NewReplacer("[", "", "]", "").Replace(t.prefix))
+}
+
+func (t *thread) run() {
+	L := lua.NewState()
+	defer L.Close()
+	if t.cfg.Notifier.Plugins.Enabled {
+		luaLibs.Preload(L)
+		if err := L.DoFile(t.cfg.Notifier.Plugins.PathToPlugin); err != nil {
+			log.Fatalf("Wasn't able to load the Lua script: %s", err)
+		}
+	}
+
+	for {
+		err := t.f(t.cfg, t.state, L)
+		t.state.Correlate(t.cfg)
+		// the check might've ended a stream that suppressed another platform's
+		if failoverErr := t.state.Failover(t.cfg, L); failoverErr != nil {
+			log.Errorf("%s Couldn't fail over to a suppressed stream: %v", t.prefix, failoverErr)
+		}
+		if err != nil {
+			if !t.fail(err) {
+				return
+			}
+			continue
+		}
+
+		t.succeed()
+		interval := t.sleeptime
+		if slices.Contains(scheduledPlatforms, strings.ToLower(t.platform)) {
+			interval = t.state.PollInterval(strings.ToLower(t.platform), t.sleeptime)
+		}
+		if recheck, ok := t.state.RecheckIn(t.platform); ok && recheck < interval {
+			log.Infof("%s Recheck of a detection coming up, sleeping for %.f seconds...", t.prefix, recheck.Seconds())
+			interval = recheck
+		} else if interval < t.sleeptime {
+			log.Infof("%s Scheduled stream coming up, sleeping for %.f seconds...", t.prefix, interval.Seconds())
+		} else {
+			log.Infof("%s Sleeping for %.f minutes...", t.prefix, t.sleeptime.Minutes())
+		}
+		t.sleep(interval)
+	}
+}
+
+func (t *thread) sleep(interval time.Duration) {
+	select {
+	case <-time.After(interval):
+	case <-t.trigger:
+		log.Infof("%s Woken up by an event", t.prefix)
+	}
+}
+
+func (t *thread) succeed() {
+	if t.failures > 0 {
+		log.Infof("%s Recovered after %d failed check(s)", t.prefix, t.failures)
+	}
+	t.class, t.delay, t.failures, t.alerted = "", 0, 0, false
+	SetMetric("loop_backoff_seconds", t.metricName(), 0)
+	if t.healthCheck != "" {
+		HealthCheck(&t.healthCheck)
+	}
+}
+
+// fail backs off after err, it returns false if the thread should stop.
+func (t *thread) fail(err error) bool {
+	class := ErrorClass(err)
+	AddMetric("loop_errors", fmt.Sprintf("%s %s", t.metricName(), class), 1)
+
+	if class == ErrorFatal {
+		log.Errorf("%s Got an error that retrying won't fix, stopping the loop: %v", t.prefix, err)
+		t.alert()
+		return false
+	}
+
+	t.failures++
+	policy := backoffs[class]
+	switch {
+	case class == ErrorParse:
+		t.delay = t.sleeptime
+	case RetryAfter(err) > 0:
+		t.delay = RetryAfter(err)
+	case class != t.class || t.delay == 0:
+		t.delay = policy.min
+	case t.delay < policy.max:
+		t.delay *= 2
+	}
+	if policy.max > 0 && t.delay > policy.max {
+		t.delay = policy.max
+	}
+	t.class = class
+
+	log.Errorf("%s Got a %s error, will restart the loop in %.f seconds: %v", t.prefix, class, t.delay.Seconds(), err)
+	SetMetric("loop_backoff_seconds", t.metricName(), int64(t.delay.Seconds()))
+	if policy.alert || t.failures >= alertAfterFailures {
+		t.alert()
+	}
+
+	t.sleep(t.delay)
+	return true
+}
+
+// alert pings the healthcheck's /fail endpoint once per failure streak.
+func (t *thread) alert() {
+	if t.alerted || t.healthCheck == "" {
+		return
+	}
+	t.alerted = true
+	AddMetric("loop_alerts", t.metricName(), 1)
+	failURL := strings.TrimSuffix(t.healthCheck, "/") + "/fail"
+	HealthCheck(&failURL)
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+)
+
+// StartHTTPServer serves http.DefaultServeMux, platforms register their
+// handlers on it before the server is started.
+func StartHTTPServer(addr string) {
+	server := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Infof("Starting the HTTP server on %s", addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("HTTP server error: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,351 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	// thumbnails are jpegs, pngs or gifs, other formats go without a hash
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/DggHQ/dggarchiver-logger"
+	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/DggHQ/dggarchiver-notifier/network"
+	"github.com/google/uuid"
+)
+
+const (
+	// sessions are forgotten this long after their last stream ended
+	sessionRetention = 24 * time.Hour
+	// biggest thumbnail that gets downloaded for hashing
+	maxThumbnailSize = 5 << 20
+)
+
+// SessionStream is one of the streams of a broadcast session.
+type SessionStream struct {
+	Platform  string `json:"platform"`
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Thumbnail string `json:"thumbnail"`
+	// perceptual hash of the thumbnail in hex, if it could be hashed
+	Hash      string    `json:"hash,omitempty"`
+	StartTime time.Time `json:"starttime"`
+	LastSeen  time.Time `json:"lastseen"`
+	Live      bool      `json:"live"`
+}
+
+func (stream *SessionStream) key() string {
+	return fmt.Sprintf("%s:%s", stream.Platform, stream.ID)
+}
+
+// Session is one broadcast simulcast on several platforms, published to the
+// <topic>.session NATS topic whenever a stream joins it.
+type Session struct {
+	ID      string                    `json:"id"`
+	Streams map[string]*SessionStream `json:"streams"`
+}
+
+// Siblings returns the platform:id of the session's streams other than key.
+func (session *Session) Siblings(key string) []string {
+	siblings := make([]string, 0, len(session.Streams))
+	for other := range session.Streams {
+		if other != key {
+			siblings = append(siblings, other)
+		}
+	}
+	sort.Strings(siblings)
+	return siblings
+}
+
+func (session *Session) ended() time.Time {
+	var ended time.Time
+	for _, stream := range session.Streams {
+		if stream.Live {
+			return time.Time{}
+		}
+		if stream.LastSeen.After(ended) {
+			ended = stream.LastSeen
+		}
+	}
+	return ended
+}
+
+// titleSimilarity returns the share of words two titles have in common.
+func titleSimilarity(a string, b string) float64 {
+	words := func(title string) map[string]bool {
+		set := make(map[string]bool)
+		for _, word := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			set[word] = true
+		}
+		return set
+	}
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	common := 0
+	for word := range wordsA {
+		if wordsB[word] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(wordsA)+len(wordsB)-common)
+}
+
+// differenceHash is the dHash of img, every bit says whether a cell of a 9x8
+// grayscale grid is brighter than the one on its right.
+func differenceHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	var grid [height][width]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// a few samples per cell are plenty for a thumbnail
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			y0 := bounds.Min.Y + y*bounds.Dy()/height
+			y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+			stepX, stepY := (x1-x0)/4+1, (y1-y0)/4+1
+			var sum, samples float64
+			for py := y0; py < y1; py += stepY {
+				for px := x0; px < x1; px += stepX {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					samples++
+				}
+			}
+			if samples > 0 {
+				grid[y][x] = sum / samples
+			}
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1 << (y*(width-1) + x)
+			}
+		}
+	}
+	return hash
+}
+
+// hashDistance returns how many bits two thumbnail hashes differ by.
+func hashDistance(a string, b string) (int, bool) {
+	hashA, errA := strconv.ParseUint(a, 16, 64)
+	hashB, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	return bits.OnesCount64(hashA ^ hashB), true
+}
+
+func thumbnailHash(cfg *config.Config, thumbnailURL string) (uint64, error) {
+	client := network.NewClient("thumbnails", nil, cfg.Notifier.Network.Options())
+	resp, err := client.Get(thumbnailURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+	img, _, err := image.Decode(io.LimitReader(resp.Body, maxThumbnailSize))
+	if err != nil {
+		return 0, err
+	}
+	return differenceHash(img), nil
+}
+
+func newSessionStream(cfg *config.Config, vod dggarchivermodel.VOD, now time.Time) *SessionStream {
+	stream := &SessionStream{
+		Platform:  vod.Platform,
+		ID:        vod.ID,
+		Title:     vod.Title,
+		Thumbnail: vod.Thumbnail,
+		StartTime: now,
+		LastSeen:  now,
+		Live:      true,
+	}
+	if start, err := time.Parse(time.RFC3339, vod.StartTime); err == nil {
+		stream.StartTime = start
+	}
+	if vod.Thumbnail != "" {
+		hash, err := thumbnailHash(cfg, vod.Thumbnail)
+		if err != nil {
+			log.Debugf("[SESSION] Couldn't hash the thumbnail of %s: %v", stream.key(), err)
+		} else {
+			stream.Hash = strconv.FormatUint(hash, 16)
+		}
+	}
+	return stream
+}
+
+// matchScore returns how well stream fits into session, or a negative score
+// if it doesn't. Only sessions with a stream on another platform that's
+// still live or ended within the window are considered, the title or the
+// thumbnail has to match on top of that.
+func matchScore(cfg *config.Config, session *Session, stream *SessionStream, now time.Time) float64 {
+	window := time.Duration(cfg.Notifier.Sessions.Window) * time.Minute
+	best := -1.0
+	for _, other := range session.Streams {
+		if other.Platform == stream.Platform || (!other.Live && now.Sub(other.LastSeen) > window) {
+			continue
+		}
+
+		score := -1.0
+		similarity := titleSimilarity(stream.Title, other.Title)
+		if similarity >= cfg.Notifier.Sessions.TitleSimilarity {
+			score = similarity
+		}
+		if distance, ok := hashDistance(stream.Hash, other.Hash); ok && distance <= cfg.Notifier.Sessions.ThumbnailDistance {
+			score = similarity + 1 - float64(distance)/64
+		}
+		// without anything to compare, streams starting together are trusted
+		if stream.Title == "" && other.Title == "" && stream.Hash == "" && other.Hash == "" {
+			if diff := stream.StartTime.Sub(other.StartTime); diff < window && diff > -window {
+				score = 0
+			}
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// Correlate puts the current streams of every platform, and extra, into
+// broadcast sessions. A stream joins the session it matches best or starts
+// a new one, streams that aren't current anymore are marked as ended.
+func (state *State) Correlate(cfg *config.Config, extra ...dggarchivermodel.VOD) {
+	if !cfg.Notifier.Sessions.Enabled {
+		return
+	}
+	now := time.Now()
+
+	state.mu.Lock()
+	current := make(map[string]dggarchivermodel.VOD)
+	currentValue := reflect.ValueOf(state.CurrentStreams)
+	for i := 0; i < currentValue.NumField(); i++ {
+		if vod := currentValue.Field(i).Interface().(dggarchivermodel.VOD); vod.ID != "" {
+			current[fmt.Sprintf("%s:%s", vod.Platform, vod.ID)] = vod
+		}
+	}
+	for _, vod := range extra {
+		current[fmt.Sprintf("%s:%s", vod.Platform, vod.ID)] = vod
+	}
+	var unknown []dggarchivermodel.VOD
+	for key, vod := range current {
+		if state.sessionOf(key) == nil {
+			unknown = append(unknown, vod)
+		}
+	}
+	state.mu.Unlock()
+
+	// thumbnails are hashed without holding the lock
+	streams := make([]*SessionStream, 0, len(unknown))
+	for _, vod := range unknown {
+		streams = append(streams, newSessionStream(cfg, vod, now))
+	}
+
+	state.mu.Lock()
+	if state.Sessions == nil {
+		state.Sessions = make(map[string]*Session)
+	}
+	for _, session := range state.Sessions {
+		for key, stream := range session.Streams {
+			_, live := current[key]
+			stream.Live = live
+			if live {
+				stream.LastSeen = now
+			}
+		}
+	}
+
+	var joined []*Session
+	for _, stream := range streams {
+		if state.sessionOf(stream.key()) != nil {
+			continue
+		}
+		var best *Session
+		bestScore := -1.0
+		for _, session := range state.Sessions {
+			if score := matchScore(cfg, session, stream, now); score > bestScore {
+				best, bestScore = session, score
+			}
+		}
+		if best == nil {
+			best = &Session{ID: uuid.NewString(), Streams: make(map[string]*SessionStream)}
+			state.Sessions[best.ID] = best
+			log.Infof("[SESSION] Started session %s with %s", best.ID, stream.key())
+		} else {
+			log.Infof("[SESSION] %s joined session %s with %s", stream.key(), best.ID, strings.Join(best.Siblings(stream.key()), ", "))
+			joined = append(joined, best)
+		}
+		best.Streams[stream.key()] = stream
+	}
+
+	for id, session := range state.Sessions {
+		if ended := session.ended(); !ended.IsZero() && now.Sub(ended) > sessionRetention {
+			delete(state.Sessions, id)
+		}
+	}
+
+	var events [][]byte
+	for _, session := range joined {
+		bytes, err := json.Marshal(session)
+		if err != nil {
+			log.Fatalf("[SESSION] Couldn't marshal session %s into a JSON object: %v", session.ID, err)
+		}
+		events = append(events, bytes)
+	}
+	state.mu.Unlock()
+
+	for _, event := range events {
+		if err := publish(cfg, "session", event, nil); err != nil {
+			log.Errorf("[SESSION] Wasn't able to send a session update: %v", err)
+		}
+	}
+	if len(streams) > 0 {
+		state.Dump()
+	}
+}
+
+// sessionOf returns the session of the stream with the given platform:id
+// key, the caller has to hold the lock.
+func (state *State) sessionOf(key string) *Session {
+	for _, session := range state.Sessions {
+		if _, ok := session.Streams[key]; ok {
+			return session
+		}
+	}
+	return nil
+}
+
+// SessionOf returns the session ID and the siblings of a stream, if it's in
+// a session.
+func (state *State) SessionOf(platform string, id string) (string, []string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	key := fmt.Sprintf("%s:%s", platform, id)
+	session := state.sessionOf(key)
+	if session == nil {
+		return "", nil
+	}
+	return session.ID, session.Siblings(key)
+}
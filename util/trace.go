@@ -0,0 +1,57 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/DggHQ/dggarchiver-notifier/config"
+	"github.com/nats-io/nats.go"
+)
+
+// Headers added to published messages, traceparent follows the W3C trace
+// context format so workers can continue the trace.
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderInstance    = "Dggarchiver-Instance"
+	HeaderJobID       = "Dggarchiver-Job-Id"
+	HeaderDetection   = "Dggarchiver-Detection"
+	// platform:id:downloader, stays the same when a job gets published
+	// again so JetStream can drop the duplicate
+	HeaderMsgID = "Nats-Msg-Id"
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("couldn't read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewTraceID returns a random W3C trace ID.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// Traceparent returns a sampled traceparent header of a new span in trace.
+func Traceparent(traceID string) string {
+	return fmt.Sprintf("00-%s-%s-01", traceID, randomHex(8))
+}
+
+// publish sends data to the <topic>.<subject> NATS topic with the instance
+// ID of the notifier, messages that aren't part of a trace start a new one.
+func publish(cfg *config.Config, subject string, data []byte, header nats.Header) error {
+	if header == nil {
+		header = nats.Header{}
+	}
+	header.Set(HeaderInstance, cfg.Notifier.Instance)
+	if header.Get(HeaderTraceparent) == "" {
+		header.Set(HeaderTraceparent, Traceparent(NewTraceID()))
+	}
+	return cfg.NATS.NatsConnection.PublishMsg(&nats.Msg{
+		Subject: fmt.Sprintf("%s.%s", cfg.NATS.Topic, subject),
+		Data:    data,
+		Header:  header,
+	})
+}
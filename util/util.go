@@ -2,46 +2,158 @@ package util
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"reflect"
+	"sync"
 	"time"
 
-	config "github.com/DggHQ/dggarchiver-config/notifier"
 	log "github.com/DggHQ/dggarchiver-logger"
 	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
+	"golang.org/x/exp/slices"
 )
 
 type State struct {
-	SearchETag     string
-	SentVODs       []string
+	mu         sync.Mutex
+	SearchETag string
+	SentVODs   []string
+	// job IDs by platform:id:downloader of the streams whose jobs only made
+	// it to some of their downloaders
+	SentJobs         map[string]string
+	Quota            Quota
+	ScheduledStreams map[string]ScheduledStream
+	Sessions         map[string]*Session
+	// last ended stream of every platform and the part numbers of the
+	// continuations that are still live
+	EndedStreams   map[string]EndedStream
+	Parts          map[string]int
 	CurrentStreams struct {
 		YouTube dggarchivermodel.VOD
 		Rumble  dggarchivermodel.VOD
 		Kick    dggarchivermodel.VOD
 	} `json:"-"`
+	// when the current stream of a platform was last seen live
+	lastSeen map[string]time.Time
+	// failover candidates by platform
+	suppressed map[string]*Candidate
+	// detections waiting for the confirmation step by platform
+	confirmations map[string]*pendingConfirmation
+	// platform:id of the streams being published right now
+	sending map[string]bool
 }
 
-func (state *State) CheckPriority(platformName string, config *config.Config) bool {
-	stateValue := reflect.ValueOf(state.CurrentStreams)
-	platformsValue := reflect.ValueOf(config.Notifier.Platforms)
-	priority := platformsValue.FieldByName(platformName).FieldByName("Priority").Int()
-	if priority <= 1 {
-		return true
+// SetCurrentStream records the stream platform, the field name of the
+// platform in the config, is live with, or that it isn't live with an empty
+// VOD. Live streams also count as seen for the priority fallback.
+func (state *State) SetCurrentStream(platform string, vod dggarchivermodel.VOD) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	current := reflect.ValueOf(&state.CurrentStreams).Elem().FieldByName(platform)
+	if previous := current.Interface().(dggarchivermodel.VOD); previous.ID != "" && previous.ID != vod.ID {
+		state.streamEnded(previous, state.lastSeen[platform])
+	}
+	current.Set(reflect.ValueOf(vod))
+	// polls in a row only count for the same stream
+	if pending, ok := state.confirmations[platform]; ok && pending.id != vod.ID {
+		delete(state.confirmations, platform)
+	}
+	if vod.ID == "" {
+		return
 	}
-	platformsFields := reflect.VisibleFields(reflect.TypeOf(config.Notifier.Platforms))
-	for _, field := range platformsFields {
-		if field.Name != platformName {
-			if platformsValue.FieldByName(field.Name).FieldByName("Priority").Int() < priority && stateValue.FieldByName(field.Name).FieldByName("ID").String() != "" {
-				return false
-			}
-		}
+	if state.lastSeen == nil {
+		state.lastSeen = make(map[string]time.Time)
 	}
-	return false
+	state.lastSeen[platform] = time.Now()
+}
+
+// CurrentStream returns the stream platform, the field name of the platform
+// in the config, is live with.
+func (state *State) CurrentStream(platform string) dggarchivermodel.VOD {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return reflect.ValueOf(state.CurrentStreams).FieldByName(platform).Interface().(dggarchivermodel.VOD)
+}
+
+func sentKey(platform string, id string) string {
+	return fmt.Sprintf("%s:%s", platform, id)
+}
+
+// WasSent reports whether stream id of platform, as in VOD.Platform, was
+// already published.
+func (state *State) WasSent(platform string, id string) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.wasSent(platform, id)
+}
+
+// wasSent is WasSent for callers that hold the lock.
+func (state *State) wasSent(platform string, id string) bool {
+	return slices.Contains(state.SentVODs, sentKey(platform, id))
+}
+
+func jobKey(platform string, id string, downloader string) string {
+	return fmt.Sprintf("%s:%s:%s", platform, id, downloader)
+}
+
+// sentJob returns the ID of the job of stream id of platform that was sent
+// to downloader, if a previous attempt got that far.
+func (state *State) sentJob(platform string, id string, downloader string) string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.SentJobs[jobKey(platform, id, downloader)]
+}
+
+func (state *State) markJobSent(platform string, id string, downloader string, jobID string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.SentJobs == nil {
+		state.SentJobs = make(map[string]string)
+	}
+	state.SentJobs[jobKey(platform, id, downloader)] = jobID
+}
+
+// MarkSent records stream id of platform as published, forgetting the jobs
+// it was sent to downloaders with.
+func (state *State) MarkSent(platform string, id string, downloaders ...string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for _, downloader := range downloaders {
+		delete(state.SentJobs, jobKey(platform, id, downloader))
+	}
+	if !state.wasSent(platform, id) {
+		state.SentVODs = append(state.SentVODs, sentKey(platform, id))
+	}
+}
+
+// claimSend claims the publishing of stream id of platform for the calling
+// thread. It returns false if the stream was already sent or another thread
+// is publishing it, every successful claim has to be followed by releaseSend.
+func (state *State) claimSend(platform string, id string) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	key := sentKey(platform, id)
+	if state.sending[key] || state.wasSent(platform, id) {
+		return false
+	}
+	if state.sending == nil {
+		state.sending = make(map[string]bool)
+	}
+	state.sending[key] = true
+	return true
+}
+
+func (state *State) releaseSend(platform string, id string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	delete(state.sending, sentKey(platform, id))
 }
 
 func (state *State) Dump() {
+	state.mu.Lock()
 	file, _ := json.MarshalIndent(state, "", "	")
+	state.mu.Unlock()
 	err := os.WriteFile("./data/state.json", file, 0o644)
 	if err != nil {
 		log.Fatalf("State dump error: %s", err)